@@ -0,0 +1,245 @@
+package decoder
+
+import (
+	"fmt"
+)
+
+// ChipProfile describes the SDM capabilities of a chip family, so that
+// keysets and file settings can be checked against what the chip supports,
+// and file settings can be parsed in the chip's format.
+type ChipProfile struct {
+	Name string
+	// KeyCount is the number of application keys.  Key numbers are 0 to KeyCount-1.
+	KeyCount int
+	// Modes are the encryption modes the chip can use for SDM.
+	Modes []EncryptionMode
+	// SDMFiles are the file numbers on which SDM can be enabled, or nil if
+	// it can be enabled on any standard data file up to MaxFileNumber.
+	SDMFiles      []int
+	MaxFileNumber int
+	// MaxAdditionalAccessRights is the number of AdditionalAccessRights a file
+	// can have, or 0 if the chip does not support them.  If it does, they
+	// follow the AccessRights in ChangeFileSettings, and the FileSize in the
+	// GetFileSettings response.
+	MaxAdditionalAccessRights int
+}
+
+var (
+	// CHIP_NTAG424_DNA is the NTAG 424 DNA (and NTAG 424 DNA TT), with SDM on its NDEF file.
+	CHIP_NTAG424_DNA = &ChipProfile{
+		Name:          "NTAG 424 DNA",
+		KeyCount:      5,
+		Modes:         []EncryptionMode{AES, LRP},
+		SDMFiles:      []int{2},
+		MaxFileNumber: 3,
+	}
+	// CHIP_DESFIRE_EV3 is the MIFARE DESFire EV3, which can have up to 14
+	// keys per application, SDM on any standard data file, and up to 7
+	// additional access rights per file.
+	CHIP_DESFIRE_EV3 = &ChipProfile{
+		Name:                      "MIFARE DESFire EV3",
+		KeyCount:                  14,
+		Modes:                     []EncryptionMode{AES},
+		MaxFileNumber:             31,
+		MaxAdditionalAccessRights: 7,
+	}
+	// CHIP_DESFIRE_LIGHT is the MIFARE DESFire Light, which has a single
+	// application with 5 keys.
+	CHIP_DESFIRE_LIGHT = &ChipProfile{
+		Name:          "MIFARE DESFire Light",
+		KeyCount:      5,
+		Modes:         []EncryptionMode{AES, LRP},
+		MaxFileNumber: 31,
+	}
+)
+
+// ChipProfiles lists the known chip profiles.
+var ChipProfiles = []*ChipProfile{CHIP_NTAG424_DNA, CHIP_DESFIRE_EV3, CHIP_DESFIRE_LIGHT}
+
+// FindChipProfile finds a chip profile by name.
+func FindChipProfile(name string) (*ChipProfile, bool) {
+	for _, profile := range ChipProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return nil, false
+}
+
+func (profile *ChipProfile) String() string {
+	return profile.Name
+}
+
+// SupportsMode tells whether the chip can use the encryption mode for SDM.
+func (profile *ChipProfile) SupportsMode(mode EncryptionMode) bool {
+	for _, supported := range profile.Modes {
+		if supported == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidKey tells whether the key number exists on the chip.
+func (profile *ChipProfile) ValidKey(keyNumber int) bool {
+	return keyNumber >= 0 && keyNumber < profile.KeyCount
+}
+
+// validAccess tells whether an access condition is a key number on the chip,
+// ACCESS_FREE, or ACCESS_NONE.
+func (profile *ChipProfile) validAccess(access int) bool {
+	return profile.ValidKey(access) || access == ACCESS_FREE || access == ACCESS_NONE
+}
+
+// ValidateKeyset checks that the keyset's mode is supported by the chip, that
+// its keys and key roles fit in the chip's key slots, and that it has a
+// MetaReadKey if it uses Random ID.
+func (profile *ChipProfile) ValidateKeyset(keyset *Keyset) error {
+	if !profile.SupportsMode(keyset.Mode) {
+		return fmt.Errorf("%w: %s does not support encryption mode %d", ErrUnsupportedByChip, profile.Name, keyset.Mode)
+	}
+	if len(keyset.Keys) > profile.KeyCount {
+		return fmt.Errorf("%w: %s has %d keys, not %d", ErrUnsupportedByChip, profile.Name, profile.KeyCount, len(keyset.Keys))
+	}
+	if keyset.RandomID && keyset.MetaReadKey == KEY_NONE {
+		return fmt.Errorf("%w: keyset has no MetaReadKey", ErrRandomID)
+	}
+	roles := []struct {
+		name string
+		slot int
+	}{
+		{"MetaReadKey", keyset.MetaReadKey},
+		{"FileReadKey", keyset.FileReadKey},
+		{"AuthenticationKey", keyset.AuthenticationKey},
+	}
+	for _, role := range roles {
+		if role.slot != KEY_NONE && !profile.ValidKey(role.slot) {
+			return fmt.Errorf("%w: %s is key %d, but %s has keys 0 to %d", ErrUnsupportedByChip, role.name, role.slot, profile.Name, profile.KeyCount-1)
+		}
+	}
+	return nil
+}
+
+// ParseFileSettings parses the response to GetFileSettings from the chip.
+func (profile *ChipProfile) ParseFileSettings(data []byte) (FileSettings, error) {
+	if len(data) < 7 {
+		return FileSettings{}, fmt.Errorf("%w: file settings are %d bytes", ErrWrongLength, len(data))
+	}
+	settings := parseFileSettingsHeader(data[1:4])
+	settings.FileType = data[0]
+	settings.FileSize = int(data[4]) | int(data[5])<<8 | int(data[6])<<16
+	data, err := profile.parseAdditionalAccessRights(&settings, data[7:])
+	if err != nil {
+		return FileSettings{}, err
+	}
+	if err := settings.parseSDMSettings(data); err != nil {
+		return FileSettings{}, err
+	}
+	return settings, nil
+}
+
+// ParseChangeFileSettings parses the data of a ChangeFileSettings command
+// for the chip (everything after the file number).
+func (profile *ChipProfile) ParseChangeFileSettings(data []byte) (FileSettings, error) {
+	if len(data) < 3 {
+		return FileSettings{}, fmt.Errorf("%w: file settings are %d bytes", ErrWrongLength, len(data))
+	}
+	settings := parseFileSettingsHeader(data[0:3])
+	data, err := profile.parseAdditionalAccessRights(&settings, data[3:])
+	if err != nil {
+		return FileSettings{}, err
+	}
+	if err := settings.parseSDMSettings(data); err != nil {
+		return FileSettings{}, err
+	}
+	return settings, nil
+}
+
+// parseAdditionalAccessRights parses the number of additional access rights
+// and the rights themselves, if the FileOption has them, and returns the rest
+// of the data.
+func (profile *ChipProfile) parseAdditionalAccessRights(settings *FileSettings, data []byte) ([]byte, error) {
+	if (settings.FileOption & FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS) == 0 {
+		return data, nil
+	}
+	if profile.MaxAdditionalAccessRights == 0 {
+		return nil, fmt.Errorf("%w: %s does not support additional access rights", ErrUnsupportedByChip, profile.Name)
+	}
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w: missing number of additional access rights", ErrWrongLength)
+	}
+	count := int(data[0])
+	if count > profile.MaxAdditionalAccessRights {
+		return nil, fmt.Errorf("%w: %s supports %d additional access rights, not %d", ErrUnsupportedByChip, profile.Name, profile.MaxAdditionalAccessRights, count)
+	}
+	data = data[1:]
+	if len(data) < 2*count {
+		return nil, fmt.Errorf("%w: additional access rights are %d bytes, expected %d", ErrWrongLength, len(data), 2*count)
+	}
+	settings.AdditionalAccessRights = make([]AccessRights, count)
+	for i := range settings.AdditionalAccessRights {
+		settings.AdditionalAccessRights[i] = parseAccessRights(data[(2 * i):(2*i + 2)])
+	}
+	return data[(2 * count):], nil
+}
+
+// ValidateFileSettings checks that the settings of the given file could be
+// used on the chip: that SDM is only enabled on files which support it, that
+// additional access rights are only used on chips which have them, and that
+// all access conditions are keys on the chip (or free or none).
+func (profile *ChipProfile) ValidateFileSettings(fileNumber int, settings *FileSettings) error {
+	if fileNumber < 0 || fileNumber > profile.MaxFileNumber {
+		return fmt.Errorf("%w: %s has no file %d", ErrUnsupportedByChip, profile.Name, fileNumber)
+	}
+
+	type accessRight struct {
+		name  string
+		value int
+	}
+	rightsOf := func(prefix string, access AccessRights) []accessRight {
+		return []accessRight{
+			{prefix + "Read", access.Read},
+			{prefix + "Write", access.Write},
+			{prefix + "ReadWrite", access.ReadWrite},
+			{prefix + "Change", access.Change},
+		}
+	}
+	rights := rightsOf("", settings.AccessRights)
+	if (settings.FileOption & FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS) != 0 {
+		if len(settings.AdditionalAccessRights) > profile.MaxAdditionalAccessRights {
+			return fmt.Errorf("%w: %s supports %d additional access rights, not %d", ErrUnsupportedByChip, profile.Name, profile.MaxAdditionalAccessRights, len(settings.AdditionalAccessRights))
+		}
+		for i, access := range settings.AdditionalAccessRights {
+			rights = append(rights, rightsOf(fmt.Sprintf("Additional %d ", i+1), access)...)
+		}
+	}
+	if (settings.FileOption & FILE_OPTION_SDM_ENABLED) != 0 {
+		if profile.SDMFiles != nil && !containsInt(profile.SDMFiles, fileNumber) {
+			return fmt.Errorf("%w: %s does not support SDM on file %d", ErrUnsupportedByChip, profile.Name, fileNumber)
+		}
+		// The chip always MACs with a key, so the file read key can't be free.
+		if settings.SDMFileRead == ACCESS_FREE {
+			return fmt.Errorf("%w: SDMFileRead cannot be free", ErrUnsupportedByChip)
+		}
+		rights = append(rights, []accessRight{
+			{"SDMMetaRead", settings.SDMMetaRead},
+			{"SDMFileRead", settings.SDMFileRead},
+			{"SDMCtrRet", settings.SDMCtrRet},
+		}...)
+	}
+	for _, right := range rights {
+		if !profile.validAccess(right.value) {
+			return fmt.Errorf("%w: %s access is key %d, but %s has keys 0 to %d", ErrUnsupportedByChip, right.name, right.value, profile.Name, profile.KeyCount-1)
+		}
+	}
+	return nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestChipProfileValidateKeyset(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	if err := keyset.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	keys := make([]Key, 14)
+	keys[13] = Key{KeyData: make([]byte, 16)}
+	desfire := Keyset{
+		Mode:              AES,
+		Keys:              keys,
+		MetaReadKey:       13,
+		FileReadKey:       13,
+		AuthenticationKey: 13,
+		Chip:              CHIP_DESFIRE_EV3,
+	}
+	if err := desfire.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	desfire.Chip = CHIP_DESFIRE_LIGHT
+	if err := desfire.Validate(); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for 14 keys on DESFire Light, received %v", err)
+	}
+	desfire.Keys = keys[0:5]
+	if err := desfire.Validate(); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for key 13 on DESFire Light, received %v", err)
+	}
+
+	desfire.Chip = CHIP_DESFIRE_EV3
+	desfire.Mode = LRP
+	if err := desfire.Validate(); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for LRP on DESFire EV3, received %v", err)
+	}
+}
+
+func TestChipProfileValidateFileSettings(t *testing.T) {
+	// ChangeFileSettings example from AN12196 (SDM with keys 1 and 2)
+	data, _ := hex.DecodeString("4000E0C1F121200000430000430000")
+	settings, _ := ParseChangeFileSettings(data)
+	if err := CHIP_NTAG424_DNA.ValidateFileSettings(2, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CHIP_NTAG424_DNA.ValidateFileSettings(3, &settings); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for SDM on file 3, received %v", err)
+	}
+	if err := CHIP_DESFIRE_EV3.ValidateFileSettings(5, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CHIP_DESFIRE_EV3.ValidateFileSettings(32, &settings); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for file 32, received %v", err)
+	}
+
+	settings.SDMMetaRead = 9
+	if err := CHIP_DESFIRE_EV3.ValidateFileSettings(5, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CHIP_DESFIRE_LIGHT.ValidateFileSettings(4, &settings); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for key 9 on DESFire Light, received %v", err)
+	}
+
+	settings.SDMMetaRead = 2
+	settings.SDMFileRead = ACCESS_FREE
+	if err := CHIP_DESFIRE_LIGHT.ValidateFileSettings(4, &settings); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for free SDMFileRead, received %v", err)
+	}
+
+	// Without SDM, only the access rights are checked
+	settings.FileOption = 0
+	if err := CHIP_NTAG424_DNA.ValidateFileSettings(3, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestChipProfileAdditionalAccessRights(t *testing.T) {
+	// DESFire EV3 ChangeFileSettings with two additional access rights and SDM
+	data, _ := hex.DecodeString("C000E002123DE0F1C1F121200000430000430000")
+	if _, err := ParseChangeFileSettings(data); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for additional access rights on NTAG 424 DNA, received %v", err)
+	}
+	if _, err := CHIP_DESFIRE_LIGHT.ParseChangeFileSettings(data); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for additional access rights on DESFire Light, received %v", err)
+	}
+	settings, err := CHIP_DESFIRE_EV3.ParseChangeFileSettings(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []AccessRights{
+		{ReadWrite: 1, Change: 2, Read: 3, Write: 0xd},
+		{ReadWrite: ACCESS_FREE, Change: 0, Read: ACCESS_NONE, Write: 1},
+	}
+	if len(settings.AdditionalAccessRights) != 2 || settings.AdditionalAccessRights[0] != expected[0] || settings.AdditionalAccessRights[1] != expected[1] {
+		t.Errorf("Bad additional access rights: %+v", settings.AdditionalAccessRights)
+	}
+	if settings.Layout.PICCDataOffset != 0x20 || settings.Layout.SDMMACOffset != 0x43 || settings.SDMMetaRead != 2 {
+		t.Errorf("Bad SDM settings: %+v", settings)
+	}
+	if hex.EncodeToString(settings.ChangeFileSettingsBytes()) != hex.EncodeToString(data) {
+		t.Errorf("Bad serialization: %s", hex.EncodeToString(settings.ChangeFileSettingsBytes()))
+	}
+	if err := CHIP_DESFIRE_EV3.ValidateFileSettings(5, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := CHIP_DESFIRE_LIGHT.ValidateFileSettings(5, &settings); !errors.Is(err, ErrUnsupportedByChip) {
+		t.Errorf("Expected unsupported error for additional access rights on DESFire Light, received %v", err)
+	}
+	settings.FileOption &^= FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS
+	if err := CHIP_DESFIRE_LIGHT.ValidateFileSettings(5, &settings); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// In the GetFileSettings response, they follow the file size
+	data, _ = hex.DecodeString("008000E000010001123D")
+	settings, err = CHIP_DESFIRE_EV3.ParseFileSettings(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if settings.FileSize != 256 || len(settings.AdditionalAccessRights) != 1 || settings.AdditionalAccessRights[0] != expected[0] {
+		t.Errorf("Bad file settings: %+v", settings)
+	}
+	if hex.EncodeToString(settings.Bytes()) != hex.EncodeToString(data) {
+		t.Errorf("Bad serialization: %s", hex.EncodeToString(settings.Bytes()))
+	}
+
+	errorcases := []struct {
+		data     string
+		expected error
+	}{
+		{"C000E0", ErrWrongLength},
+		{"C000E002123D", ErrWrongLength},
+		{"C000E008123D123D123D123D123D123D123D123D", ErrUnsupportedByChip},
+	}
+	for _, errorcase := range errorcases {
+		data, _ := hex.DecodeString(errorcase.data)
+		if _, err := CHIP_DESFIRE_EV3.ParseChangeFileSettings(data); !errors.Is(err, errorcase.expected) {
+			t.Errorf("Expected %v for %s, received %v", errorcase.expected, errorcase.data, err)
+		}
+	}
+}
+
+func TestKeysetConfigChip(t *testing.T) {
+	config := "version: 1\nkeysets:\n  - name: a\n    chip: MIFARE DESFire EV3\n    mode: AES\n    keys:\n      - slot: 13\n        key: 00000000000000000000000000000000\n    meta_read_key: 13\n"
+	configs, err := ParseKeysetConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if configs[0].Keyset.Chip != CHIP_DESFIRE_EV3 || configs[0].Keyset.MetaReadKey != 13 {
+		t.Errorf("Wrong keyset: %+v", configs[0].Keyset)
+	}
+
+	data, err := MarshalKeysetConfig(configs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reparsed, err := ParseKeysetConfig(data)
+	if err != nil || reparsed[0].Keyset.Chip != CHIP_DESFIRE_EV3 {
+		t.Errorf("Chip not written (%v):\n%s", err, data)
+	}
+}
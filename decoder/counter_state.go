@@ -0,0 +1,236 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// COUNTER_STATE_HISTORY is the number of the latest counters accepted by each
+// node which a CounterState keeps for each UID.
+const COUNTER_STATE_HISTORY = 64
+
+// CounterState is a CounterStore which can be merged with the states of
+// other replicas (e.g., verification services in several regions which
+// sync periodically).  For each UID, it keeps the latest counters accepted
+// by each node (up to COUNTER_STATE_HISTORY), so merging is just taking the
+// union for each node, which gives the same result no matter the order or
+// how often states are merged.  The last accepted counter for a UID is the
+// highest for any node.
+//
+// Since each tap has its own counter, two nodes which both accepted the same
+// counter for a UID accepted the same tap, so one of them was a replay.  These
+// are found when the states are merged (even if newer taps have been accepted
+// since), and are kept and shared with other replicas, so they are reported
+// by Conflicts from then on.
+type CounterState struct {
+	mutex sync.Mutex
+	// Node identifies this replica.  Counters accepted through CompareAndSwap are recorded for this node.
+	Node string
+	// counters has the accepted counters for each UID and node, highest first.
+	counters map[string]map[string][]int32
+	// conflicts has the nodes which accepted each conflicting counter for each UID.
+	conflicts map[string]map[int32]map[string]bool
+}
+
+// CounterConflict is a counter for a UID which was accepted by more than one node.
+type CounterConflict struct {
+	Uid     string   `json:"uid"`
+	Counter int32    `json:"counter"`
+	Nodes   []string `json:"nodes"`
+}
+
+// counterStateExport is the serialized form of a CounterState.
+type counterStateExport struct {
+	Node      string                        `json:"node"`
+	Counters  map[string]map[string][]int32 `json:"counters"`
+	Conflicts []CounterConflict             `json:"conflicts"`
+}
+
+// NewCounterState creates an empty state for the given node.
+func NewCounterState(node string) *CounterState {
+	return &CounterState{
+		Node:      node,
+		counters:  map[string]map[string][]int32{},
+		conflicts: map[string]map[int32]map[string]bool{},
+	}
+}
+
+func (state *CounterState) Load(uid string) (int32, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	return state.load(uid), nil
+}
+
+func (state *CounterState) load(uid string) int32 {
+	counter := int32(COUNTER_NONE)
+	for _, nodeCounters := range state.counters[uid] {
+		if nodeCounters[0] > counter {
+			counter = nodeCounters[0]
+		}
+	}
+	return counter
+}
+
+func (state *CounterState) CompareAndSwap(uid string, oldCounter int32, newCounter int32) (bool, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.load(uid) != oldCounter {
+		return false, nil
+	}
+	state.add(uid, state.Node, newCounter)
+	return true, nil
+}
+
+// add records that the node accepted the counter for the UID, noting a
+// conflict if another node accepted it too.
+func (state *CounterState) add(uid string, node string, counter int32) {
+	nodeCounters, ok := state.counters[uid]
+	if !ok {
+		nodeCounters = map[string][]int32{}
+		state.counters[uid] = nodeCounters
+	}
+
+	history := nodeCounters[node]
+	idx := sort.Search(len(history), func(i int) bool { return history[i] <= counter })
+	if idx < len(history) && history[idx] == counter {
+		return
+	}
+	if idx >= COUNTER_STATE_HISTORY {
+		// Older than anything kept
+		return
+	}
+	history = append(history, 0)
+	copy(history[(idx+1):], history[idx:])
+	history[idx] = counter
+	if len(history) > COUNTER_STATE_HISTORY {
+		history = history[0:COUNTER_STATE_HISTORY]
+	}
+	nodeCounters[node] = history
+
+	for otherNode, otherHistory := range nodeCounters {
+		if otherNode == node {
+			continue
+		}
+		otherIdx := sort.Search(len(otherHistory), func(i int) bool { return otherHistory[i] <= counter })
+		if otherIdx < len(otherHistory) && otherHistory[otherIdx] == counter {
+			state.addConflict(uid, counter, []string{node, otherNode})
+		}
+	}
+}
+
+func (state *CounterState) addConflict(uid string, counter int32, nodes []string) {
+	counterConflicts, ok := state.conflicts[uid]
+	if !ok {
+		counterConflicts = map[int32]map[string]bool{}
+		state.conflicts[uid] = counterConflicts
+	}
+	conflictNodes, ok := counterConflicts[counter]
+	if !ok {
+		conflictNodes = map[string]bool{}
+		counterConflicts[counter] = conflictNodes
+	}
+	for _, node := range nodes {
+		conflictNodes[node] = true
+	}
+}
+
+// Export serializes the state (as JSON), for sending to other replicas.
+func (state *CounterState) Export() ([]byte, error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	return json.Marshal(counterStateExport{
+		Node:      state.Node,
+		Counters:  state.counters,
+		Conflicts: state.conflictList(),
+	})
+}
+
+// Import merges an exported state from another replica into this one,
+// giving the conflicts in the merged state.
+func (state *CounterState) Import(data []byte) ([]CounterConflict, error) {
+	other := counterStateExport{}
+	if err := json.Unmarshal(data, &other); err != nil {
+		return nil, fmt.Errorf("invalid counter state: %w", err)
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.merge(other.Counters, other.Conflicts)
+	return state.conflictList(), nil
+}
+
+// Merge merges another replica's state into this one, giving the conflicts in the merged state.
+func (state *CounterState) Merge(other *CounterState) []CounterConflict {
+	if other == state {
+		return state.Conflicts()
+	}
+	other.mutex.Lock()
+	counters := map[string]map[string][]int32{}
+	for uid, nodeCounters := range other.counters {
+		counters[uid] = map[string][]int32{}
+		for node, history := range nodeCounters {
+			counters[uid][node] = append([]int32{}, history...)
+		}
+	}
+	conflicts := other.conflictList()
+	other.mutex.Unlock()
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.merge(counters, conflicts)
+	return state.conflictList()
+}
+
+func (state *CounterState) merge(counters map[string]map[string][]int32, conflicts []CounterConflict) {
+	for uid, nodeCounters := range counters {
+		for node, history := range nodeCounters {
+			for _, counter := range history {
+				state.add(uid, node, counter)
+			}
+		}
+	}
+	for _, conflict := range conflicts {
+		state.addConflict(conflict.Uid, conflict.Counter, conflict.Nodes)
+	}
+}
+
+// Conflicts gives each counter which was accepted by more than one node for
+// the same UID, sorted by UID and counter.
+func (state *CounterState) Conflicts() []CounterConflict {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	return state.conflictList()
+}
+
+func (state *CounterState) conflictList() []CounterConflict {
+	conflicts := []CounterConflict{}
+	for uid, counterConflicts := range state.conflicts {
+		for counter, conflictNodes := range counterConflicts {
+			nodes := []string{}
+			for node := range conflictNodes {
+				nodes = append(nodes, node)
+			}
+			sort.Strings(nodes)
+			conflicts = append(conflicts, CounterConflict{
+				Uid:     uid,
+				Counter: counter,
+				Nodes:   nodes,
+			})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Uid != conflicts[j].Uid {
+			return conflicts[i].Uid < conflicts[j].Uid
+		}
+		return conflicts[i].Counter < conflicts[j].Counter
+	})
+	return conflicts
+}
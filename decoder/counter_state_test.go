@@ -0,0 +1,143 @@
+package decoder
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCounterStateConformance(t *testing.T) {
+	testCounterStore(t, NewCounterState("a"))
+}
+
+func TestCounterStateMerge(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	tap, _ := keyset.Verify("0471862A506380000003", "637618472FE7D110")
+	other, _ := keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+
+	east := NewCounterState("us-east")
+	west := NewCounterState("us-west")
+	eastGuard := NewReplayGuard(east)
+	westGuard := NewReplayGuard(west)
+
+	// The same tap is accepted in both regions before they sync
+	if verdict, _ := eastGuard.Check(tap); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	if verdict, _ := westGuard.Check(tap); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	// A different tag is only seen in the west
+	if verdict, _ := westGuard.Check(other); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+
+	exported, err := west.Export()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conflicts, err := east.Import(exported)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []CounterConflict{
+		CounterConflict{
+			Uid:     tap.Meta.UidHex(),
+			Counter: 3,
+			Nodes:   []string{"us-east", "us-west"},
+		},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Wrong conflicts: %+v", conflicts)
+	}
+	if !reflect.DeepEqual(west.Merge(east), expected) {
+		t.Errorf("Wrong conflicts: %+v", west.Conflicts())
+	}
+
+	// Both replicas now agree, and merging again changes nothing
+	eastExport, _ := east.Export()
+	westExport, _ := west.Export()
+	if !bytes.Equal(bytes.Replace(eastExport, []byte("us-east\",\"counters"), []byte("us-west\",\"counters"), 1), westExport) {
+		t.Errorf("Replicas differ after merging: %s / %s", eastExport, westExport)
+	}
+	east.Merge(west)
+	eastExportAgain, _ := east.Export()
+	if !bytes.Equal(eastExport, eastExportAgain) {
+		t.Errorf("Merging is not idempotent: %s / %s", eastExport, eastExportAgain)
+	}
+
+	// After syncing, taps seen in the other region are replays
+	if verdict, _ := eastGuard.Check(other); verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s", verdict)
+	}
+	tap.Meta.ReadCounter = 4
+	if verdict, _ := eastGuard.Check(tap); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	if !reflect.DeepEqual(east.Conflicts(), expected) {
+		t.Errorf("Conflict not kept after a newer tap: %+v", east.Conflicts())
+	}
+
+	if _, err := east.Import([]byte("{")); err == nil {
+		t.Errorf("Expected an error for an invalid export")
+	}
+}
+
+func TestCounterStateConflictBeforeNewerTap(t *testing.T) {
+	east := NewCounterState("us-east")
+	west := NewCounterState("us-west")
+	central := NewCounterState("eu-central")
+
+	// The same tap is accepted in both regions, and then a newer tap in the
+	// east, all before they sync
+	east.CompareAndSwap("0471862a506380", COUNTER_NONE, 3)
+	west.CompareAndSwap("0471862a506380", COUNTER_NONE, 3)
+	east.CompareAndSwap("0471862a506380", 3, 4)
+
+	expected := []CounterConflict{
+		CounterConflict{
+			Uid:     "0471862a506380",
+			Counter: 3,
+			Nodes:   []string{"us-east", "us-west"},
+		},
+	}
+	if conflicts := east.Merge(west); !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Wrong conflicts: %+v", conflicts)
+	}
+	if counter, _ := east.Load("0471862a506380"); counter != 4 {
+		t.Errorf("Wrong counter. Expected 4, received %d", counter)
+	}
+
+	// The conflict is passed on to replicas which merge later
+	exported, _ := east.Export()
+	if conflicts, _ := central.Import(exported); !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Wrong conflicts: %+v", conflicts)
+	}
+}
+
+func TestCounterStateMergeOrder(t *testing.T) {
+	replicas := []*CounterState{NewCounterState("a"), NewCounterState("b"), NewCounterState("c")}
+	for idx, replica := range replicas {
+		for uid := int64(0); uid < 5; uid++ {
+			meta := Meta{
+				Uid: uid,
+			}
+			replica.CompareAndSwap(meta.UidHex(), COUNTER_NONE, int32(uid)*int32(idx+1)%7)
+		}
+	}
+
+	forward := NewCounterState("x")
+	backward := NewCounterState("x")
+	for idx := range replicas {
+		forward.Merge(replicas[idx])
+		backward.Merge(replicas[len(replicas)-1-idx])
+	}
+	forwardExport, _ := forward.Export()
+	backwardExport, _ := backward.Export()
+	if !bytes.Equal(forwardExport, backwardExport) {
+		t.Errorf("Merge order changed the result: %s / %s", forwardExport, backwardExport)
+	}
+	if !reflect.DeepEqual(forward.Conflicts(), backward.Conflicts()) {
+		t.Errorf("Merge order changed the conflicts")
+	}
+}
@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"sync"
+)
+
+// COUNTER_NONE is the counter of a UID which has never been accepted.
+const COUNTER_NONE = -1
+
+// CounterStore records the last accepted read counter for each UID (as given by Meta#UidHex).
+// Implementations must be safe for concurrent use.
+type CounterStore interface {
+	// Load gives the last accepted read counter for the UID, or COUNTER_NONE.
+	Load(uid string) (int32, error)
+	// CompareAndSwap atomically sets the counter for the UID to newCounter,
+	// but only if it is still oldCounter.  It tells whether the counter was set.
+	CompareAndSwap(uid string, oldCounter int32, newCounter int32) (bool, error)
+}
+
+// MemoryCounterStore is a CounterStore which keeps counters in memory.
+type MemoryCounterStore struct {
+	mutex    sync.Mutex
+	counters map[string]int32
+}
+
+// NewMemoryCounterStore creates an empty MemoryCounterStore.
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{
+		counters: map[string]int32{},
+	}
+}
+
+func (store *MemoryCounterStore) Load(uid string) (int32, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	counter, ok := store.counters[uid]
+	if !ok {
+		return COUNTER_NONE, nil
+	}
+	return counter, nil
+}
+
+func (store *MemoryCounterStore) CompareAndSwap(uid string, oldCounter int32, newCounter int32) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	counter, ok := store.counters[uid]
+	if !ok {
+		counter = COUNTER_NONE
+	}
+	if counter != oldCounter {
+		return false, nil
+	}
+	store.counters[uid] = newCounter
+	return true, nil
+}
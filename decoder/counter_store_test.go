@@ -0,0 +1,87 @@
+package decoder
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// testCounterStore is the conformance suite for CounterStore implementations.
+func testCounterStore(t *testing.T, store CounterStore) {
+	counter, err := store.Load("0471862a506380")
+	if err != nil || counter != COUNTER_NONE {
+		t.Errorf("Expected no counter, received %d (%v)", counter, err)
+	}
+
+	steps := []struct {
+		oldCounter int32
+		newCounter int32
+		swapped    bool
+	}{
+		{COUNTER_NONE, 5, true},
+		{COUNTER_NONE, 6, false},
+		{4, 6, false},
+		{5, 7, true},
+		{5, 8, false},
+		{7, 0x7fffff, true},
+	}
+	for idx, step := range steps {
+		swapped, err := store.CompareAndSwap("0471862a506380", step.oldCounter, step.newCounter)
+		if err != nil || swapped != step.swapped {
+			t.Errorf("Step %d: expected %t, received %t (%v)", idx, step.swapped, swapped, err)
+		}
+	}
+	counter, err = store.Load("0471862a506380")
+	if err != nil || counter != 0x7fffff {
+		t.Errorf("Expected %d, received %d (%v)", 0x7fffff, counter, err)
+	}
+
+	// Concurrent taps for several UIDs, with each counter arriving several times
+	guard := NewReplayGuard(store)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := map[string]int{}
+	for uid := int64(1); uid <= 4; uid++ {
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(uid int64) {
+				defer wg.Done()
+				for counter := int32(1); counter <= 25; counter++ {
+					result := Result{
+						Meta:      NewMeta(uid, counter, nil),
+						Validated: true,
+					}
+					verdict, err := guard.Check(result)
+					if err != nil {
+						t.Errorf("Unexpected error: %v", err)
+						return
+					}
+					if verdict == VERDICT_ACCEPTED {
+						mutex.Lock()
+						accepted[fmt.Sprintf("%s/%d", result.Meta.UidHex(), counter)]++
+						mutex.Unlock()
+					}
+				}
+			}(uid)
+		}
+	}
+	wg.Wait()
+	for key, count := range accepted {
+		if count != 1 {
+			t.Errorf("%s accepted %d times", key, count)
+		}
+	}
+	for uid := int64(1); uid <= 4; uid++ {
+		meta := Meta{
+			Uid: uid,
+		}
+		counter, err := store.Load(meta.UidHex())
+		if err != nil || counter != 25 {
+			t.Errorf("Expected 25 for %s, received %d (%v)", meta.UidHex(), counter, err)
+		}
+	}
+}
+
+func TestMemoryCounterStore(t *testing.T) {
+	testCounterStore(t, NewMemoryCounterStore())
+}
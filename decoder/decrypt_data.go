@@ -4,31 +4,83 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/johnnyb/gocrypto/lrp"
 )
 
 func DecryptAES(key []byte, data []byte) []byte {
+	return DecryptAESWithIV(key, make([]byte, 16), data)
+}
+
+// DecryptAESWithIV decrypts AES-CBC data using the given IV.
+func DecryptAESWithIV(key []byte, iv []byte, data []byte) []byte {
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		panic(err)
 	}
 
-	cbc := cipher.NewCBCDecrypter(c, make([]byte, 16))
+	cbc := cipher.NewCBCDecrypter(c, iv)
 	dst := make([]byte, len(data))
 	cbc.CryptBlocks(dst, data)
 
 	return dst
 }
 
+// EncryptAESBlock encrypts a single block with AES in ECB mode.
+func EncryptAESBlock(key []byte, block []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	dst := make([]byte, len(block))
+	c.Encrypt(dst, block)
+
+	return dst
+}
+
+// DecryptLRP decrypts LRP data using the given updated key number.
+// The counter is big-endian, and may be up to 8 bytes long.  Its size
+// (in nibbles) is fixed by the number of bytes given, since leading
+// zero nibbles change the result.
 func DecryptLRP(key []byte, keynum int, counterBytes []byte, data []byte) []byte {
 	mc := lrp.NewStandardMultiCipher(key)
 
-	counter := binary.BigEndian.Uint64(counterBytes)
+	paddedCounter := make([]byte, 8)
+	copy(paddedCounter[8-len(counterBytes):], counterBytes)
 	c := mc.Cipher(keynum)
-	c.Counter = uint64(counter)
-	// Not sure if I should force CounterSize to 16 or just leave it to `normal'
-	c.CounterSize = 16
+	c.Counter = binary.BigEndian.Uint64(paddedCounter)
+	c.CounterSize = 2 * len(counterBytes)
 
 	return c.DecryptAll(data, false)
 }
+
+// checkAESInput returns an error (instead of panicking) if the key or data
+// could not be used for AES-CBC.
+func checkAESInput(key []byte, data []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("%w: AES key is %d bytes", ErrWrongLength, len(key))
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return fmt.Errorf("%w: AES data is %d bytes, expected a non-zero multiple of %d", ErrWrongLength, len(data), aes.BlockSize)
+	}
+	return nil
+}
+
+// checkLRPInput returns an error (instead of panicking) if the key, counter, or data
+// could not be used for LRP decryption.
+func checkLRPInput(key []byte, counterBytes []byte, data []byte) error {
+	if len(key) != 16 {
+		return fmt.Errorf("%w: LRP key is %d bytes", ErrWrongLength, len(key))
+	}
+	if len(counterBytes) == 0 || len(counterBytes) > 8 {
+		return fmt.Errorf("%w: LRP counter is %d bytes", ErrWrongLength, len(counterBytes))
+	}
+	if len(data) == 0 || len(data)%16 != 0 {
+		return fmt.Errorf("%w: LRP data is %d bytes, expected a non-zero multiple of 16", ErrWrongLength, len(data))
+	}
+	return nil
+}
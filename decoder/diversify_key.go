@@ -1,9 +1,171 @@
 package decoder
 
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"fmt"
+)
+
+// Diversification is the key diversification algorithm used by a diversified Key.
+type Diversification int
+
+const (
+	// DIVERSIFY_LEGACY diversifies a 16-byte AES-128 master key the way DiversifyKey
+	// always has, and is the default.  It is the usual CMAC of 0x01 followed by the
+	// diversification input (of any length), which is only the same as
+	// DIVERSIFY_AES128 if the input is 16 to 31 bytes (e.g., a 7-byte UID followed
+	// by 9 to 24 bytes of application data).
+	DIVERSIFY_LEGACY Diversification = iota
+	// DIVERSIFY_AES128 diversifies a 16-byte AES-128 master key (AN10922 section 2.2).
+	DIVERSIFY_AES128 Diversification = iota
+	// DIVERSIFY_AES192 diversifies a 24-byte AES-192 master key (AN10922 section 2.3).
+	DIVERSIFY_AES192 Diversification = iota
+	// DIVERSIFY_2TDEA diversifies a 16-byte two-key triple DES master key (AN10922 section 2.4).
+	DIVERSIFY_2TDEA Diversification = iota
+	// DIVERSIFY_3TDEA diversifies a 24-byte three-key triple DES master key (AN10922 section 2.5).
+	DIVERSIFY_3TDEA Diversification = iota
+)
+
+// DiversifyKey diversifies an AES-128 master key with DIVERSIFY_LEGACY (kept for
+// existing tags; use DiversifyKeyWith and DIVERSIFY_AES128 for AN10922).  It
+// panics if the master key is the wrong length.
 func DiversifyKey(masterKey []byte, application []byte, identifier []byte) []byte {
-	diversificationData := []byte{0x01} // I don't think the 0x01 actually does anything, but the standard says it should be there
-	diversificationData = append(diversificationData, identifier...)
-	diversificationData = append(diversificationData, application...)
-	newKey := AESMAC(masterKey, diversificationData)
+	newKey, err := DiversifyKeyWith(DIVERSIFY_LEGACY, masterKey, application, identifier)
+	if err != nil {
+		panic(err)
+	}
 	return newKey
 }
+
+// DiversifyKeyWith diversifies a master key using the given algorithm.  The
+// diversification input is the identifier (the UID), followed by the
+// application data (usually the AID and a system identifier).  It can be 1
+// to 31 bytes for AES, and 1 to 15 bytes for TDEA (DIVERSIFY_LEGACY takes
+// any length).
+//
+// Each AN10922 algorithm CMACs one or more blocks of a constant (0x01, 0x11/0x12,
+// 0x21/0x22, or 0x31/0x32/0x33) followed by the diversification input,
+// padded to 32 bytes (AES) or 16 bytes (TDEA).
+func DiversifyKeyWith(variant Diversification, masterKey []byte, application []byte, identifier []byte) ([]byte, error) {
+	input := append(append([]byte{}, identifier...), application...)
+
+	if variant == DIVERSIFY_LEGACY {
+		if len(masterKey) != 16 {
+			return nil, fmt.Errorf("%w: master key is %d bytes, expected 16", ErrWrongLength, len(masterKey))
+		}
+		return AESMAC(masterKey, append([]byte{0x01}, input...)), nil
+	}
+
+	var block cipher.Block
+	var err error
+	var keyLength, inputLength int
+	var constants []byte
+	switch variant {
+	case DIVERSIFY_AES128:
+		keyLength, inputLength, constants = 16, 32, []byte{0x01}
+	case DIVERSIFY_AES192:
+		keyLength, inputLength, constants = 24, 32, []byte{0x11, 0x12}
+	case DIVERSIFY_2TDEA:
+		keyLength, inputLength, constants = 16, 16, []byte{0x21, 0x22}
+	case DIVERSIFY_3TDEA:
+		keyLength, inputLength, constants = 24, 16, []byte{0x31, 0x32, 0x33}
+	default:
+		return nil, fmt.Errorf("%w: key diversification %d", ErrUnknownMode, variant)
+	}
+	if len(masterKey) != keyLength {
+		return nil, fmt.Errorf("%w: master key is %d bytes, expected %d", ErrWrongLength, len(masterKey), keyLength)
+	}
+	if len(input) == 0 || len(input) >= inputLength {
+		return nil, fmt.Errorf("%w: diversification input is %d bytes, expected 1 to %d", ErrWrongLength, len(input), inputLength-1)
+	}
+
+	switch variant {
+	case DIVERSIFY_AES128, DIVERSIFY_AES192:
+		block, err = aes.NewCipher(masterKey)
+	case DIVERSIFY_2TDEA:
+		block, err = des.NewTripleDESCipher(append(append([]byte{}, masterKey...), masterKey[0:8]...))
+	case DIVERSIFY_3TDEA:
+		block, err = des.NewTripleDESCipher(masterKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	macs := [][]byte{}
+	for _, constant := range constants {
+		macs = append(macs, paddedCMAC(block, append([]byte{constant}, input...), inputLength))
+	}
+
+	if variant == DIVERSIFY_AES192 {
+		// The middle of the key is from both MACs
+		newKey := append([]byte{}, macs[0][0:8]...)
+		for idx := 0; idx < 8; idx++ {
+			newKey = append(newKey, macs[0][8+idx]^macs[1][idx])
+		}
+		return append(newKey, macs[1][8:16]...), nil
+	}
+
+	newKey := []byte{}
+	for _, mac := range macs {
+		newKey = append(newKey, mac...)
+	}
+	return newKey, nil
+}
+
+// paddedCMAC computes the CMAC of data padded to length bytes, as AN10922
+// does.  Unlike the usual CMAC, data is padded to the full length even if
+// it is shorter than one block.
+func paddedCMAC(block cipher.Block, data []byte, length int) []byte {
+	blockSize := block.BlockSize()
+	subkey := make([]byte, blockSize)
+	block.Encrypt(subkey, subkey)
+	subkey = cmacSubkey(subkey)
+
+	padded := make([]byte, length)
+	copy(padded, data)
+	if len(data) < length {
+		padded[len(data)] = 0x80
+		subkey = cmacSubkey(subkey)
+	}
+	for idx := range subkey {
+		padded[length-blockSize+idx] ^= subkey[idx]
+	}
+
+	mac := make([]byte, blockSize)
+	for offset := 0; offset < length; offset += blockSize {
+		for idx := range mac {
+			mac[idx] ^= padded[offset+idx]
+		}
+		block.Encrypt(mac, mac)
+	}
+	return mac
+}
+
+// cmacSubkey doubles a value in GF(2^n), giving the next CMAC subkey.
+func cmacSubkey(value []byte) []byte {
+	result := make([]byte, len(value))
+	for idx := range value {
+		result[idx] = value[idx] << 1
+		if idx+1 < len(value) {
+			result[idx] |= value[idx+1] >> 7
+		}
+	}
+	if value[0]&0x80 != 0 {
+		if len(value) == 8 {
+			result[len(result)-1] ^= 0x1B
+		} else {
+			result[len(result)-1] ^= 0x87
+		}
+	}
+	return result
+}
+
+// SetTDEAKeyVersion stores a key version in the parity bits (the least
+// significant bit of each byte) of the first 8 bytes of a TDEA key, as
+// DESFire chips do.  The parity bits are not used for encryption.
+func SetTDEAKeyVersion(key []byte, version byte) {
+	for idx := 0; idx < 8 && idx < len(key); idx++ {
+		key[idx] = (key[idx] & 0xFE) | ((version >> (7 - idx)) & 0x01)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"bytes"
 	"encoding/hex"
+	"errors"
 )
 
 func TestDiversifyKey(t *testing.T) {
@@ -17,3 +18,110 @@ func TestDiversifyKey(t *testing.T) {
 		t.Errorf("Bad key diversification: Expected %s, received %s", hex.EncodeToString(expectedKey), hex.EncodeToString(key))
 	}
 }
+
+func TestDiversifyKeyWith(t *testing.T) {
+	// The examples from AN10922.  The TDEA examples have key version 0x55 in the parity bits.
+	testcases := []struct {
+		variant     Diversification
+		masterKey   string
+		application string
+		keyVersion  byte
+		expected    string
+	}{
+		{DIVERSIFY_AES128, "00112233445566778899AABBCCDDEEFF", "3042F54E585020416275", 0, "A8DD63A3B89D54B37CA802473FDA9175"},
+		{DIVERSIFY_AES192, "00112233445566778899AABBCCDDEEFF0102030405060708", "3042F54E585020416275", 0, "CE39C8E1CD82D9A7BEDBE9D74AF59B23176755EE7586E12C"},
+		{DIVERSIFY_2TDEA, "00112233445566778899AABBCCDDEEFF", "3042F54E58502041", 0x55, "16F9587D9E8910C96B9648D006107DD7"},
+		{DIVERSIFY_3TDEA, "00112233445566778899AABBCCDDEEFF0102030405060708", "3042F54E5850", 0x55, "2E0DD03774D3FA9B5705AB0BDA91CA0B55B8E07FCDBF10EC"},
+	}
+	chipUid, _ := hex.DecodeString("04782E21801D80")
+	for idx, testcase := range testcases {
+		masterKey, _ := hex.DecodeString(testcase.masterKey)
+		application, _ := hex.DecodeString(testcase.application)
+		key := Key{
+			KeyData:     masterKey,
+			Diversified: true,
+			Application: application,
+			Variant:     testcase.variant,
+			KeyVersion:  testcase.keyVersion,
+		}
+		keyBytes := key.GenerateKeyBytes(chipUid)
+		expectedKey, _ := hex.DecodeString(testcase.expected)
+		if !bytes.Equal(keyBytes, expectedKey) {
+			t.Errorf("Testcase %d: bad key diversification: Expected %s, received %s", idx, testcase.expected, hex.EncodeToString(keyBytes))
+		}
+	}
+}
+
+func TestDiversifyKeyPadding(t *testing.T) {
+	masterKey, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	chipUid, _ := hex.DecodeString("04782E21801D80")
+
+	// When the diversification input fills the second block, AN10922 is the same as the usual CMAC
+	for length := 9; length <= 24; length++ {
+		application := bytes.Repeat([]byte{0xA5}, length)
+		key, err := DiversifyKeyWith(DIVERSIFY_AES128, masterKey, application, chipUid)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := AESMAC(masterKey, append(append([]byte{0x01}, chipUid...), application...))
+		if !bytes.Equal(key, expected) {
+			t.Errorf("Bad key diversification for %d bytes: Expected %s, received %s", length, hex.EncodeToString(expected), hex.EncodeToString(key))
+		}
+	}
+
+	// Shorter input is still padded to 32 bytes
+	key, _ := DiversifyKeyWith(DIVERSIFY_AES128, masterKey, nil, chipUid)
+	if bytes.Equal(key, AESMAC(masterKey, append([]byte{0x01}, chipUid...))) {
+		t.Errorf("Short diversification input was only padded to one block")
+	}
+
+	// But not with DIVERSIFY_LEGACY, which DiversifyKey has always used
+	application, _ := hex.DecodeString("3042F54E")
+	expected := AESMAC(masterKey, append(append([]byte{0x01}, chipUid...), application...))
+	if key := DiversifyKey(masterKey, application, chipUid); !bytes.Equal(key, expected) {
+		t.Errorf("DiversifyKey changed: Expected %s, received %s", hex.EncodeToString(expected), hex.EncodeToString(key))
+	}
+	legacy := Key{KeyData: masterKey, Diversified: true, Application: application, Variant: DIVERSIFY_LEGACY}
+	if key := legacy.GenerateKeyBytes(chipUid); !bytes.Equal(key, expected) {
+		t.Errorf("Bad legacy key diversification: Expected %s, received %s", hex.EncodeToString(expected), hex.EncodeToString(key))
+	}
+
+	// Keys which don't choose a variant are diversified as they always have been,
+	// including with diversification input too long for AN10922
+	expectedKey, _ := hex.DecodeString("31c4cf9aef2c4e37014b4f15aabda19d")
+	if !bytes.Equal(expected, expectedKey) {
+		t.Errorf("Legacy key diversification changed: Expected %s, received %s", hex.EncodeToString(expectedKey), hex.EncodeToString(expected))
+	}
+	zeroValue := Key{KeyData: masterKey, Diversified: true, Application: application}
+	if key := zeroValue.GenerateKeyBytes(chipUid); !bytes.Equal(key, expectedKey) {
+		t.Errorf("Bad default key diversification: Expected %s, received %s", hex.EncodeToString(expectedKey), hex.EncodeToString(key))
+	}
+	zeroValue.Application = bytes.Repeat([]byte{0xA5}, 40)
+	longExpected, _ := hex.DecodeString("9696a45ce078fa2514d4136bdb471d38")
+	if key := zeroValue.GenerateKeyBytes(chipUid); !bytes.Equal(key, longExpected) {
+		t.Errorf("Bad default key diversification for long input: Expected %s, received %s", hex.EncodeToString(longExpected), hex.EncodeToString(key))
+	}
+
+	errorcases := []struct {
+		variant     Diversification
+		keyLength   int
+		inputLength int
+	}{
+		{DIVERSIFY_AES128, 16, 32},
+		{DIVERSIFY_AES128, 16, 0},
+		{DIVERSIFY_AES128, 24, 10},
+		{DIVERSIFY_AES192, 16, 10},
+		{DIVERSIFY_2TDEA, 16, 16},
+		{DIVERSIFY_3TDEA, 16, 10},
+		{DIVERSIFY_LEGACY, 24, 10},
+	}
+	for idx, errorcase := range errorcases {
+		_, err := DiversifyKeyWith(errorcase.variant, make([]byte, errorcase.keyLength), make([]byte, errorcase.inputLength), nil)
+		if !errors.Is(err, ErrWrongLength) {
+			t.Errorf("Testcase %d: expected wrong length error, received %v", idx, err)
+		}
+	}
+	if _, err := DiversifyKeyWith(Diversification(99), masterKey, nil, chipUid); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("Expected unknown mode error, received %v", err)
+	}
+}
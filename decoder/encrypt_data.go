@@ -0,0 +1,41 @@
+package decoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/johnnyb/gocrypto/lrp"
+)
+
+// EncryptAES encrypts with AES-CBC and a zero IV (the inverse of DecryptAES).
+func EncryptAES(key []byte, data []byte) []byte {
+	return EncryptAESWithIV(key, make([]byte, 16), data)
+}
+
+// EncryptAESWithIV encrypts AES-CBC data using the given IV.
+func EncryptAESWithIV(key []byte, iv []byte, data []byte) []byte {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	cbc := cipher.NewCBCEncrypter(c, iv)
+	dst := make([]byte, len(data))
+	cbc.CryptBlocks(dst, data)
+
+	return dst
+}
+
+// EncryptLRP encrypts LRP data without padding (the inverse of DecryptLRP).
+func EncryptLRP(key []byte, keynum int, counterBytes []byte, data []byte) []byte {
+	mc := lrp.NewStandardMultiCipher(key)
+
+	paddedCounter := make([]byte, 8)
+	copy(paddedCounter[8-len(counterBytes):], counterBytes)
+	c := mc.Cipher(keynum)
+	c.Counter = binary.BigEndian.Uint64(paddedCounter)
+	c.CounterSize = 2 * len(counterBytes)
+
+	return c.EncryptAll(data, false)
+}
@@ -0,0 +1,37 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+func TestEncryptAES(t *testing.T) {
+	key, _ := hex.DecodeString(zeroKey)
+	data, _ := hex.DecodeString("870432272aaa6180eedca6567298ba89")
+	result := hex.EncodeToString(EncryptAES(key, data))
+	if result != "ab9a48a5286493d4476603f9f441f918" {
+		t.Errorf("Did not encrypt correctly: %s", result)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 16)
+		random.Read(key)
+		counter := make([]byte, 1+random.Intn(8))
+		random.Read(counter)
+		data := make([]byte, 16*(1+random.Intn(4)))
+		random.Read(data)
+
+		if result := DecryptAES(key, EncryptAES(key, data)); !bytes.Equal(result, data) {
+			t.Errorf("AES round trip failed: %s != %s", hex.EncodeToString(result), hex.EncodeToString(data))
+		}
+		keynum := random.Intn(16)
+		if result := DecryptLRP(key, keynum, counter, EncryptLRP(key, keynum, counter, data)); !bytes.Equal(result, data) {
+			t.Errorf("LRP round trip failed: %s != %s", hex.EncodeToString(result), hex.EncodeToString(data))
+		}
+	}
+}
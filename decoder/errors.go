@@ -0,0 +1,47 @@
+package decoder
+
+import (
+	"errors"
+)
+
+// Sentinel errors returned by the error-returning decode API.  Errors are
+// usually wrapped with more detail, so compare them using errors.Is.
+var (
+	// ErrBadHex means a hex-encoded field could not be decoded.
+	ErrBadHex = errors.New("invalid hex string")
+	// ErrWrongLength means a key, message, or field has the wrong length.
+	ErrWrongLength = errors.New("wrong length")
+	// ErrUnknownMode means the keyset has no usable EncryptionMode.
+	ErrUnknownMode = errors.New("unknown encryption mode")
+	// ErrInvalidPICCDataTag means the PICCDataTag could not have been generated by a chip
+	// (usually because the PICCData was decrypted with the wrong key).
+	ErrInvalidPICCDataTag = errors.New("invalid PICCDataTag")
+	// ErrMissingKey means the keyset does not have a key in the slot required for the operation.
+	ErrMissingKey = errors.New("missing key slot")
+	// ErrInvalidTemplate means a URL template could not be parsed.
+	ErrInvalidTemplate = errors.New("invalid URL template")
+	// ErrTemplateMismatch means a URL does not match its template.
+	ErrTemplateMismatch = errors.New("URL does not match template")
+	// ErrInvalidNDEF means an NDEF message could not be parsed, or has no SUN message.
+	ErrInvalidNDEF = errors.New("invalid NDEF message")
+	// ErrNoReadCounter means replay protection (or decrypting file data) was requested for a message without a read counter.
+	ErrNoReadCounter = errors.New("read counter is not mirrored")
+	// ErrInvalidConfig means a keyset configuration file could not be loaded.
+	ErrInvalidConfig = errors.New("invalid keyset configuration")
+	// ErrUnknownTenant means no tenant's keyset could be found for a message.
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrUnknownUID means a KeyProvider has no key for a tag.
+	ErrUnknownUID = errors.New("unknown UID")
+	// ErrNoUID means an operation (such as diversifying a key, or looking up a per-tag key) needs the UID of a message which does not mirror it.
+	ErrNoUID = errors.New("UID is not mirrored")
+	// ErrInvalidPublicKey means an originality signature public key is not a valid point on its curve.
+	ErrInvalidPublicKey = errors.New("invalid public key")
+	// ErrInvalidTamperStatus means a mirrored tag tamper status is not one a chip could have generated.
+	ErrInvalidTamperStatus = errors.New("invalid tamper status")
+	// ErrUnsupportedByChip means a keyset or file settings use something the keyset's chip does not have.
+	ErrUnsupportedByChip = errors.New("not supported by chip")
+	// ErrReadCounterExhausted means a VirtualTag's read counter has reached MAX_READ_COUNTER.
+	ErrReadCounterExhausted = errors.New("read counter exhausted")
+	// ErrRandomID means a keyset for tags with Random ID was used without encrypted PICCData.
+	ErrRandomID = errors.New("Random ID needs encrypted PICCData")
+)
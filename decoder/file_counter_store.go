@@ -0,0 +1,275 @@
+package decoder
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileCounterStore is a CounterStore which persists counters in an
+// append-only log file.  Each accepted counter is appended (and synced)
+// before it is reported as accepted, so counters survive crashes.  Lines
+// which were only partially written before a crash are discarded when the
+// file is reopened.  The log is compacted automatically once it has many
+// more entries than UIDs.  Only one FileCounterStore should use a file at
+// a time.
+//
+// If an entry can't be written (e.g., the disk is full), whatever part of it
+// was written is truncated away, so that later entries are not lost.  If even
+// that fails, the store refuses further updates until it has been compacted.
+type FileCounterStore struct {
+	mutex    sync.Mutex
+	path     string
+	file     counterLogFile
+	counters map[string]int32
+	entries  int
+	// length is the length of the log up to the end of the last complete entry.
+	length int64
+	// failed is set if the log could not be restored after a failed write.
+	failed error
+	// compactErr is the error from the last automatic compaction.
+	compactErr error
+}
+
+// counterLogFile is the part of *os.File used for the log, so that tests can
+// simulate write failures.
+type counterLogFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Seek(offset int64, whence int) (int64, error)
+	Close() error
+}
+
+// minCompactionEntries is the minimum log size before compacting automatically.
+const minCompactionEntries = 1024
+
+// OpenFileCounterStore opens (or creates) a counter log file.
+func OpenFileCounterStore(path string) (*FileCounterStore, error) {
+	store := &FileCounterStore{
+		path:     path,
+		counters: map[string]int32{},
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	validLength, err := store.readLog(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Discard anything after the last complete entry
+	if err := file.Truncate(validLength); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(validLength, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	store.file = file
+	store.length = validLength
+
+	return store, nil
+}
+
+// readLog loads the counters from the log, returning the length of the valid part of the log.
+func (store *FileCounterStore) readLog(file io.Reader) (int64, error) {
+	reader := bufio.NewReader(file)
+	var validLength int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return validLength, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		uid, counter, ok := parseCounterLogEntry(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			return validLength, nil
+		}
+		// Entries are replayed in order, so the last one written wins
+		store.counters[uid] = counter
+		store.entries++
+		validLength += int64(len(line))
+	}
+}
+
+// formatCounterLogEntry formats a log line (without the newline) as "uid counter crc".
+func formatCounterLogEntry(uid string, counter int32) string {
+	entry := uid + " " + strconv.FormatInt(int64(counter), 10)
+	return fmt.Sprintf("%s %08x", entry, crc32.ChecksumIEEE([]byte(entry)))
+}
+
+func parseCounterLogEntry(line string) (uid string, counter int32, ok bool) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 3 {
+		return "", 0, false
+	}
+	parsedCounter, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+	if formatCounterLogEntry(fields[0], int32(parsedCounter)) != line {
+		return "", 0, false
+	}
+	return fields[0], int32(parsedCounter), true
+}
+
+func (store *FileCounterStore) Load(uid string) (int32, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	counter, ok := store.counters[uid]
+	if !ok {
+		return COUNTER_NONE, nil
+	}
+	return counter, nil
+}
+
+func (store *FileCounterStore) CompareAndSwap(uid string, oldCounter int32, newCounter int32) (bool, error) {
+	if strings.ContainsAny(uid, " \n") || uid == "" {
+		return false, fmt.Errorf("invalid UID for counter log: %q", uid)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.file == nil {
+		return false, os.ErrClosed
+	}
+	counter, ok := store.counters[uid]
+	if !ok {
+		counter = COUNTER_NONE
+	}
+	if counter != oldCounter {
+		return false, nil
+	}
+
+	if err := store.append(formatCounterLogEntry(uid, newCounter) + "\n"); err != nil {
+		return false, err
+	}
+	store.counters[uid] = newCounter
+	store.entries++
+
+	// The counter is committed whether or not compaction works, so a
+	// compaction error is only reported by CompactionError.
+	if store.entries > minCompactionEntries && store.entries > 4*len(store.counters) {
+		store.compactErr = store.compact()
+	}
+
+	return true, nil
+}
+
+// append writes and syncs a log entry.  If that fails, the log is truncated
+// back to the last complete entry.
+func (store *FileCounterStore) append(line string) error {
+	if store.failed != nil {
+		return store.failed
+	}
+	_, err := store.file.Write([]byte(line))
+	if err == nil {
+		err = store.file.Sync()
+	}
+	if err != nil {
+		if _, restoreErr := store.file.Seek(store.length, io.SeekStart); restoreErr != nil {
+			store.failed = fmt.Errorf("counter log could not be restored after %v: %w", err, restoreErr)
+		} else if restoreErr := store.file.Truncate(store.length); restoreErr != nil {
+			store.failed = fmt.Errorf("counter log could not be restored after %v: %w", err, restoreErr)
+		}
+		return err
+	}
+	store.length += int64(len(line))
+	return nil
+}
+
+// CompactionError gives the error from the last automatic compaction, if it
+// failed.  The log keeps growing until a compaction succeeds.
+func (store *FileCounterStore) CompactionError() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.compactErr
+}
+
+// Compact rewrites the log with only the latest counter for each UID.  This
+// also recovers a store which refused updates after a failed write.
+func (store *FileCounterStore) Compact() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.file == nil {
+		return os.ErrClosed
+	}
+	return store.compact()
+}
+
+func (store *FileCounterStore) compact() error {
+	// Write the new log next to the old one, then atomically replace it
+	tmpPath := store.path + ".compact"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	length, err := writeCompactedLog(tmpFile, store.counters)
+	if err == nil {
+		err = os.Rename(tmpPath, store.path)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(store.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	store.file.Close()
+	store.file = tmpFile
+	store.entries = len(store.counters)
+	store.length = length
+	store.failed = nil
+	store.compactErr = nil
+	return nil
+}
+
+// writeCompactedLog writes and syncs one entry for each counter, returning
+// the length of the log.
+func writeCompactedLog(file *os.File, counters map[string]int32) (int64, error) {
+	writer := bufio.NewWriter(file)
+	var length int64
+	for uid, counter := range counters {
+		line := formatCounterLogEntry(uid, counter) + "\n"
+		if _, err := writer.WriteString(line); err != nil {
+			return 0, err
+		}
+		length += int64(len(line))
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+	return length, file.Sync()
+}
+
+// Close closes the log file.
+func (store *FileCounterStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
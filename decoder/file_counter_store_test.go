@@ -0,0 +1,193 @@
+package decoder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileCounterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	store, err := OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testCounterStore(t, store)
+	store.Close()
+
+	// Counters survive reopening, even with a partially-written entry at the end
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString("0471862a506380 99")
+	file.Close()
+	store, err = OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if counter, _ := store.Load("0471862a506380"); counter != 0x7fffff {
+		t.Errorf("Wrong counter after reopening: %d", counter)
+	}
+	if swapped, err := store.CompareAndSwap("0471862a506380", 0x7fffff, 0x800000); !swapped || err != nil {
+		t.Errorf("Could not update after reopening: %v", err)
+	}
+
+	sizeBefore, _ := os.Stat(path)
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sizeAfter, _ := os.Stat(path)
+	if sizeAfter.Size() >= sizeBefore.Size() {
+		t.Errorf("Compaction did not shrink the log: %d >= %d", sizeAfter.Size(), sizeBefore.Size())
+	}
+	if swapped, err := store.CompareAndSwap("0471862a506380", 0x800000, 0x800001); !swapped || err != nil {
+		t.Errorf("Could not update after compacting: %v", err)
+	}
+	store.Close()
+
+	store, err = OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+	if counter, _ := store.Load("0471862a506380"); counter != 0x800001 {
+		t.Errorf("Wrong counter after compacting: %d", counter)
+	}
+	if counter, _ := store.Load("00000000000000"); counter != COUNTER_NONE {
+		t.Errorf("Wrong counter for unknown UID: %d", counter)
+	}
+}
+
+// failingLogFile writes only part of the next entry, then fails.
+type failingLogFile struct {
+	*os.File
+	failWrite    bool
+	failTruncate bool
+}
+
+func (file *failingLogFile) Write(data []byte) (int, error) {
+	if file.failWrite {
+		file.failWrite = false
+		written, _ := file.File.Write(data[0:(len(data) / 2)])
+		return written, syscall.ENOSPC
+	}
+	return file.File.Write(data)
+}
+
+func (file *failingLogFile) Truncate(size int64) error {
+	if file.failTruncate {
+		return syscall.EIO
+	}
+	return file.File.Truncate(size)
+}
+
+func TestFileCounterStoreWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	store, err := OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.CompareAndSwap("0471862a506380", COUNTER_NONE, 1)
+	failing := &failingLogFile{File: store.file.(*os.File), failWrite: true}
+	store.file = failing
+
+	if swapped, err := store.CompareAndSwap("0471862a506380", 1, 2); swapped || !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Expected a failed write, received %t (%v)", swapped, err)
+	}
+	if swapped, err := store.CompareAndSwap("0421272aaa6180", COUNTER_NONE, 5); !swapped || err != nil {
+		t.Errorf("Could not update after a failed write: %v", err)
+	}
+	store.Close()
+
+	// The partial entry doesn't hide the later one
+	store, err = OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if counter, _ := store.Load("0471862a506380"); counter != 1 {
+		t.Errorf("Wrong counter after reopening. Expected 1, received %d", counter)
+	}
+	if counter, _ := store.Load("0421272aaa6180"); counter != 5 {
+		t.Errorf("Wrong counter after reopening. Expected 5, received %d", counter)
+	}
+
+	// If the partial entry can't be removed, updates are refused until compaction
+	store.file = &failingLogFile{File: store.file.(*os.File), failWrite: true, failTruncate: true}
+	store.CompareAndSwap("0471862a506380", 1, 2)
+	if swapped, err := store.CompareAndSwap("0421272aaa6180", 5, 6); swapped || err == nil {
+		t.Errorf("Expected updates to be refused")
+	}
+	if err := store.Compact(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if swapped, err := store.CompareAndSwap("0421272aaa6180", 5, 6); !swapped || err != nil {
+		t.Errorf("Could not update after compacting: %v", err)
+	}
+	store.Close()
+}
+
+func TestFileCounterStoreCompactionFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	store, err := OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+	// The compacted log can't be written where a directory is in the way
+	os.Mkdir(path+".compact", 0700)
+
+	counter := int32(COUNTER_NONE)
+	for idx := int32(1); idx <= minCompactionEntries+1; idx++ {
+		if swapped, err := store.CompareAndSwap("0471862a506380", counter, idx); !swapped || err != nil {
+			t.Fatalf("Counter %d not committed: %v", idx, err)
+		}
+		counter = idx
+	}
+	if store.CompactionError() == nil {
+		t.Errorf("Expected a compaction error")
+	}
+
+	os.Remove(path + ".compact")
+	if err := store.Compact(); err != nil || store.CompactionError() != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestFileCounterStoreReplayOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	// A later entry with a lower counter (e.g., after a reset) wins
+	log := formatCounterLogEntry("0471862a506380", 5) + "\n" + formatCounterLogEntry("0471862a506380", 3) + "\n"
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store, err := OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+	if counter, _ := store.Load("0471862a506380"); counter != 3 {
+		t.Errorf("Wrong counter after replaying the log.  Expected 3, received %d", counter)
+	}
+}
+
+func TestFileCounterStoreRenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	store, err := OpenFileCounterStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+	if swapped, err := store.CompareAndSwap("0471862a506380", COUNTER_NONE, 1); !swapped || err != nil {
+		t.Fatalf("Counter not committed: %v", err)
+	}
+
+	// The compacted log can't replace a non-empty directory
+	os.Remove(path)
+	os.MkdirAll(filepath.Join(path, "in-the-way"), 0700)
+	if err := store.Compact(); err == nil {
+		t.Errorf("Expected a compaction error")
+	}
+	if _, err := os.Stat(path + ".compact"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Temporary compaction file left behind (%v)", err)
+	}
+}
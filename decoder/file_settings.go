@@ -0,0 +1,246 @@
+package decoder
+
+import (
+	"fmt"
+)
+
+// Special access condition values, used in place of a key number.
+const (
+	// ACCESS_FREE means access is granted without authentication (or, for SDM,
+	// that the data is mirrored in plain).
+	ACCESS_FREE = 0xe
+	// ACCESS_NONE means access is never granted (or, for SDM, that the data is not mirrored).
+	ACCESS_NONE = 0xf
+)
+
+// Bits of FileSettings.FileOption.
+const (
+	// FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS means the file has AdditionalAccessRights (DESFire EV3 only).
+	FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS = 0b10000000
+	FILE_OPTION_SDM_ENABLED              = 0b01000000
+)
+
+// Bits of FileSettings.SDMOptions.
+const (
+	SDM_OPTION_UID            = 0b10000000
+	SDM_OPTION_READ_CTR       = 0b01000000
+	SDM_OPTION_READ_CTR_LIMIT = 0b00100000
+	SDM_OPTION_ENC_FILE_DATA  = 0b00010000
+	// SDM_OPTION_TT_STATUS mirrors the tamper status (NTAG 424 DNA TT only).
+	SDM_OPTION_TT_STATUS      = 0b00001000
+	SDM_OPTION_ASCII_ENCODING = 0b00000001
+)
+
+// AccessRights gives the key number (or ACCESS_FREE/ACCESS_NONE) for each kind of file access.
+type AccessRights struct {
+	Read      int
+	Write     int
+	ReadWrite int
+	Change    int
+}
+
+// FileSettings is the file settings structure of a standard data file, as
+// returned by GetFileSettings or sent with ChangeFileSettings.
+// SDMMetaRead, SDMFileRead, and SDMCtrRet are key numbers (or ACCESS_FREE/ACCESS_NONE).
+// The SDM fields are only used if FileOption has FILE_OPTION_SDM_ENABLED set.
+type FileSettings struct {
+	// FileType and FileSize are only in the GetFileSettings response.
+	FileType     byte
+	FileSize     int
+	FileOption   byte
+	AccessRights AccessRights
+	// AdditionalAccessRights is only used if FileOption has FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS set.
+	AdditionalAccessRights []AccessRights
+
+	SDMOptions  byte
+	SDMMetaRead int
+	SDMFileRead int
+	SDMCtrRet   int
+	Layout      SDMLayout
+	// SDMReadCtrLimit is only used if SDMOptions has SDM_OPTION_READ_CTR_LIMIT set.
+	SDMReadCtrLimit int
+}
+
+// ParseFileSettings parses the response to GetFileSettings from an NTAG 424
+// DNA.  Use ChipProfile#ParseFileSettings for other chips.
+func ParseFileSettings(data []byte) (FileSettings, error) {
+	return CHIP_NTAG424_DNA.ParseFileSettings(data)
+}
+
+// ParseChangeFileSettings parses the data of a ChangeFileSettings command
+// (everything after the file number) for an NTAG 424 DNA.  Use
+// ChipProfile#ParseChangeFileSettings for other chips.
+func ParseChangeFileSettings(data []byte) (FileSettings, error) {
+	return CHIP_NTAG424_DNA.ParseChangeFileSettings(data)
+}
+
+// parseFileSettingsHeader parses the FileOption and AccessRights.
+func parseFileSettingsHeader(header []byte) FileSettings {
+	settings := FileSettings{
+		FileOption:      header[0],
+		AccessRights:    parseAccessRights(header[1:3]),
+		Layout:          NewSDMLayout(),
+		SDMMetaRead:     ACCESS_NONE,
+		SDMFileRead:     ACCESS_NONE,
+		SDMCtrRet:       ACCESS_NONE,
+		SDMReadCtrLimit: OFFSET_NONE,
+	}
+	return settings
+}
+
+// parseAccessRights parses the 2-byte access rights.
+func parseAccessRights(data []byte) AccessRights {
+	return AccessRights{
+		ReadWrite: int(data[0] >> 4),
+		Change:    int(data[0] & 0x0f),
+		Read:      int(data[1] >> 4),
+		Write:     int(data[1] & 0x0f),
+	}
+}
+
+// Bytes serializes the access rights.
+func (access AccessRights) Bytes() []byte {
+	return []byte{
+		byte(access.ReadWrite<<4 | access.Change),
+		byte(access.Read<<4 | access.Write),
+	}
+}
+
+// parseSDMSettings parses the SDM part of the settings, if SDM is enabled.
+func (settings *FileSettings) parseSDMSettings(data []byte) error {
+	if (settings.FileOption & FILE_OPTION_SDM_ENABLED) == 0 {
+		if len(data) != 0 {
+			return fmt.Errorf("%w: %d extra bytes after file settings", ErrWrongLength, len(data))
+		}
+		return nil
+	}
+	if len(data) < 3 {
+		return fmt.Errorf("%w: SDM settings are %d bytes", ErrWrongLength, len(data))
+	}
+	settings.SDMOptions = data[0]
+	settings.SDMCtrRet = int(data[1] & 0x0f)
+	settings.SDMMetaRead = int(data[2] >> 4)
+	settings.SDMFileRead = int(data[2] & 0x0f)
+	data = data[3:]
+
+	for _, field := range settings.offsetFields() {
+		if len(data) < 3 {
+			return fmt.Errorf("%w: missing %s", ErrWrongLength, field.name)
+		}
+		*field.value = int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+		data = data[3:]
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("%w: %d extra bytes after file settings", ErrWrongLength, len(data))
+	}
+
+	return nil
+}
+
+type offsetField struct {
+	name  string
+	value *int
+}
+
+// offsetFields lists the 3-byte fields which are present for the SDM settings, in order.
+func (settings *FileSettings) offsetFields() []offsetField {
+	fields := []offsetField{}
+	options := settings.SDMOptions
+	layout := &settings.Layout
+	if settings.SDMMetaRead == ACCESS_FREE {
+		if (options & SDM_OPTION_UID) != 0 {
+			fields = append(fields, offsetField{"UIDOffset", &layout.UIDOffset})
+		}
+		if (options & SDM_OPTION_READ_CTR) != 0 {
+			fields = append(fields, offsetField{"SDMReadCtrOffset", &layout.SDMReadCtrOffset})
+		}
+	} else if settings.SDMMetaRead != ACCESS_NONE {
+		fields = append(fields, offsetField{"PICCDataOffset", &layout.PICCDataOffset})
+	}
+	if (options & SDM_OPTION_TT_STATUS) != 0 {
+		fields = append(fields, offsetField{"TTStatusOffset", &layout.TTStatusOffset})
+	}
+	if settings.SDMFileRead != ACCESS_NONE {
+		fields = append(fields, offsetField{"SDMMACInputOffset", &layout.SDMMACInputOffset})
+		if (options & SDM_OPTION_ENC_FILE_DATA) != 0 {
+			fields = append(fields, offsetField{"SDMENCOffset", &layout.SDMENCOffset})
+			fields = append(fields, offsetField{"SDMENCLength", &layout.SDMENCLength})
+		}
+		fields = append(fields, offsetField{"SDMMACOffset", &layout.SDMMACOffset})
+	}
+	if (options & SDM_OPTION_READ_CTR_LIMIT) != 0 {
+		fields = append(fields, offsetField{"SDMReadCtrLimit", &settings.SDMReadCtrLimit})
+	}
+	return fields
+}
+
+// Bytes serializes the settings in the format of the GetFileSettings response.
+func (settings *FileSettings) Bytes() []byte {
+	data := []byte{settings.FileType, settings.FileOption}
+	data = append(data, settings.AccessRights.Bytes()...)
+	data = append(data, byte(settings.FileSize), byte(settings.FileSize>>8), byte(settings.FileSize>>16))
+	data = append(data, settings.additionalAccessRightsBytes()...)
+	return append(data, settings.sdmSettingsBytes()...)
+}
+
+// ChangeFileSettingsBytes serializes the settings as the data for a
+// ChangeFileSettings command (everything after the file number).
+func (settings *FileSettings) ChangeFileSettingsBytes() []byte {
+	data := []byte{settings.FileOption}
+	data = append(data, settings.AccessRights.Bytes()...)
+	data = append(data, settings.additionalAccessRightsBytes()...)
+	return append(data, settings.sdmSettingsBytes()...)
+}
+
+func (settings *FileSettings) additionalAccessRightsBytes() []byte {
+	if (settings.FileOption & FILE_OPTION_ADDITIONAL_ACCESS_RIGHTS) == 0 {
+		return []byte{}
+	}
+	data := []byte{byte(len(settings.AdditionalAccessRights))}
+	for _, access := range settings.AdditionalAccessRights {
+		data = append(data, access.Bytes()...)
+	}
+	return data
+}
+
+func (settings *FileSettings) sdmSettingsBytes() []byte {
+	if (settings.FileOption & FILE_OPTION_SDM_ENABLED) == 0 {
+		return []byte{}
+	}
+	data := []byte{
+		settings.SDMOptions,
+		byte(0xf0 | settings.SDMCtrRet),
+		byte(settings.SDMMetaRead<<4 | settings.SDMFileRead),
+	}
+	for _, field := range settings.offsetFields() {
+		value := *field.value
+		data = append(data, byte(value), byte(value>>8), byte(value>>16))
+	}
+	return data
+}
+
+// Keyset creates a keyset for decoding messages from a file with these settings.
+// The keys are the application keys of the chip, indexed by key number.  The
+// meta read key is SDMMetaRead, and both the file read key and the authentication
+// key are SDMFileRead (the chip MACs with the SDM file read key).  Since file
+// settings do not include the encryption mode, it must be given.
+func (settings *FileSettings) Keyset(mode EncryptionMode, keys []Key) Keyset {
+	keyset := Keyset{
+		Mode:              mode,
+		Keys:              keys,
+		MetaReadKey:       KEY_NONE,
+		FileReadKey:       KEY_NONE,
+		AuthenticationKey: KEY_NONE,
+	}
+	if (settings.FileOption & FILE_OPTION_SDM_ENABLED) == 0 {
+		return keyset
+	}
+	if settings.SDMMetaRead < ACCESS_FREE {
+		keyset.MetaReadKey = settings.SDMMetaRead
+	}
+	if settings.SDMFileRead < ACCESS_FREE {
+		keyset.FileReadKey = settings.SDMFileRead
+		keyset.AuthenticationKey = settings.SDMFileRead
+	}
+	return keyset
+}
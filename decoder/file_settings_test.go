@@ -0,0 +1,148 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestParseChangeFileSettings(t *testing.T) {
+	// ChangeFileSettings example from AN12196
+	data, _ := hex.DecodeString("4000E0C1F121200000430000430000")
+	settings, err := ParseChangeFileSettings(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if settings.AccessRights.Read != ACCESS_FREE || settings.AccessRights.Write != 0 || settings.AccessRights.ReadWrite != 0 || settings.AccessRights.Change != 0 {
+		t.Errorf("Bad access rights: %+v", settings.AccessRights)
+	}
+	if settings.SDMMetaRead != 2 || settings.SDMFileRead != 1 || settings.SDMCtrRet != 1 {
+		t.Errorf("Bad SDM access rights: %d / %d / %d", settings.SDMMetaRead, settings.SDMFileRead, settings.SDMCtrRet)
+	}
+	layout := settings.Layout
+	if layout.PICCDataOffset != 0x20 || layout.SDMMACInputOffset != 0x43 || layout.SDMMACOffset != 0x43 {
+		t.Errorf("Bad layout: %+v", layout)
+	}
+	if layout.UIDOffset != OFFSET_NONE || layout.SDMReadCtrOffset != OFFSET_NONE || layout.SDMENCOffset != OFFSET_NONE {
+		t.Errorf("Bad layout: %+v", layout)
+	}
+	if hex.EncodeToString(settings.ChangeFileSettingsBytes()) != hex.EncodeToString(data) {
+		t.Errorf("Bad serialization: %s", hex.EncodeToString(settings.ChangeFileSettingsBytes()))
+	}
+
+	keys := []Key{Key{}, Key{}, Key{}, Key{}, Key{}}
+	keyset := settings.Keyset(AES, keys)
+	if keyset.MetaReadKey != 2 || keyset.FileReadKey != 1 || keyset.AuthenticationKey != 1 {
+		t.Errorf("Bad keyset: %d / %d / %d", keyset.MetaReadKey, keyset.FileReadKey, keyset.AuthenticationKey)
+	}
+}
+
+func TestParseChangeFileSettingsTTStatus(t *testing.T) {
+	// The AN12196 example, with the tamper status of an NTAG 424 DNA TT mirrored
+	// at 0x41 (between the PICCData and the MAC input)
+	data, _ := hex.DecodeString("4000E0C9F121200000410000440000440000")
+	settings, err := ParseChangeFileSettings(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	layout := settings.Layout
+	if layout.PICCDataOffset != 0x20 || layout.TTStatusOffset != 0x41 || layout.SDMMACInputOffset != 0x44 || layout.SDMMACOffset != 0x44 {
+		t.Errorf("Bad layout: %+v", layout)
+	}
+	if hex.EncodeToString(settings.ChangeFileSettingsBytes()) != hex.EncodeToString(data) {
+		t.Errorf("Bad serialization: %s", hex.EncodeToString(settings.ChangeFileSettingsBytes()))
+	}
+
+	// Without the offset, it is too short
+	if _, err := ParseChangeFileSettings(data[0:15]); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+}
+
+func TestParseFileSettings(t *testing.T) {
+	testcases := []string{
+		// Plain UID and counter mirroring, with a MAC
+		"004000E0000100C1F1E1200000300000400000400000",
+		// Encrypted PICCData and file data, with a read counter limit
+		"004000E0000100F1F121200000430000430000200000630000FFFF00",
+		// SDM disabled
+		"000000E0000100",
+		// Plain UID and counter mirroring, with the tamper status
+		"004000E0000100C9F1E1200000300000360000400000400000",
+	}
+	for _, testcase := range testcases {
+		data, _ := hex.DecodeString(testcase)
+		settings, err := ParseFileSettings(data)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if settings.FileSize != 256 {
+			t.Errorf("Bad file size: %d", settings.FileSize)
+		}
+		if hex.EncodeToString(settings.Bytes()) != hex.EncodeToString(data) {
+			t.Errorf("Bad serialization: %s", hex.EncodeToString(settings.Bytes()))
+		}
+	}
+
+	data, _ := hex.DecodeString(testcases[0])
+	settings, _ := ParseFileSettings(data)
+	if settings.Layout.UIDOffset != 0x20 || settings.Layout.SDMReadCtrOffset != 0x30 || settings.Layout.PICCDataOffset != OFFSET_NONE {
+		t.Errorf("Bad layout: %+v", settings.Layout)
+	}
+	keyset := settings.Keyset(AES, []Key{Key{}, Key{}})
+	if keyset.MetaReadKey != KEY_NONE || keyset.AuthenticationKey != 1 {
+		t.Errorf("Bad keyset: %d / %d", keyset.MetaReadKey, keyset.AuthenticationKey)
+	}
+
+	data, _ = hex.DecodeString(testcases[1])
+	settings, _ = ParseFileSettings(data)
+	if settings.Layout.SDMENCOffset != 0x43 || settings.Layout.SDMENCLength != 0x20 || settings.Layout.SDMMACOffset != 0x63 || settings.SDMReadCtrLimit != 0xffff {
+		t.Errorf("Bad layout: %+v / %d", settings.Layout, settings.SDMReadCtrLimit)
+	}
+
+	data, _ = hex.DecodeString(testcases[2])
+	settings, _ = ParseFileSettings(data)
+	keyset = settings.Keyset(AES, []Key{Key{}})
+	if keyset.MetaReadKey != KEY_NONE || keyset.FileReadKey != KEY_NONE || keyset.AuthenticationKey != KEY_NONE {
+		t.Errorf("Bad keyset: %+v", keyset)
+	}
+
+	data, _ = hex.DecodeString(testcases[3])
+	settings, _ = ParseFileSettings(data)
+	if settings.Layout.UIDOffset != 0x20 || settings.Layout.SDMReadCtrOffset != 0x30 || settings.Layout.TTStatusOffset != 0x36 || settings.Layout.SDMMACOffset != 0x40 {
+		t.Errorf("Bad layout: %+v", settings.Layout)
+	}
+}
+
+func TestParseFileSettingsErrors(t *testing.T) {
+	testcases := []string{
+		"",
+		"004000E0000100",
+		"004000E0000100C1F121200000430000",
+		"004000E0000100C1F12120000043000043000000",
+	}
+	for _, testcase := range testcases {
+		data, _ := hex.DecodeString(testcase)
+		if _, err := ParseFileSettings(data); !errors.Is(err, ErrWrongLength) {
+			t.Errorf("Expected wrong length for %s, received %v", testcase, err)
+		}
+	}
+}
+
+func TestFileSettingsVerify(t *testing.T) {
+	url := "https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6"
+	// PICCDataOffset 0x25, SDMMACInputOffset = SDMENCOffset 0x4a, SDMENCLength 0x20, SDMMACOffset 0x70
+	data, _ := hex.DecodeString("4000E0D1F1002500004A00004A0000200000700000")
+	settings, err := ParseChangeFileSettings(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyset := settings.Keyset(AES, []Key{Key{KeyData: make([]byte, 16)}})
+	result, err := keyset.VerifyLayoutURL(settings.Layout, url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated || string(result.FileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad result: %t / %s", result.Validated, result.FileData)
+	}
+}
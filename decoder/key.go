@@ -8,15 +8,43 @@ type Key struct {
 	// Diversified tells whether this is a straight key or a diversified key.
 	Diversified bool
 	// Application tells the "application data" to use during diversification on diversified keys.
+	// For AN10922, this is the AID followed by the system identifier.
 	Application []byte
+	// Variant is the algorithm used for diversified keys.  The default is DIVERSIFY_LEGACY,
+	// so AN10922 keys (e.g., for DESFire) must set DIVERSIFY_AES128 or another variant.
+	Variant     Diversification
+	// KeyVersion is stored in the parity bits of diversified TDEA keys, as DESFire chips do.
+	KeyVersion  byte
+	// Provider, if set, looks up a per-tag key by UID instead (KeyData, Diversified, and Application are ignored).
+	Provider    KeyProvider
 }
 
 // Generates a key.  Diversifies the key if it is set to be a diversified key.
 // If it is not a diversified key, uidBytes can be nil.
+// This panics if the key can't be generated (e.g., if a Provider doesn't have the UID's key).
 func(key *Key) GenerateKeyBytes(uidBytes []byte) []byte {
+	keyBytes, err := key.generateKeyBytes(uidBytes)
+	if err != nil {
+		panic(err)
+	}
+	return keyBytes
+}
+
+// generateKeyBytes is the error-returning version of GenerateKeyBytes.
+func (key *Key) generateKeyBytes(uidBytes []byte) ([]byte, error) {
+	if key.Provider != nil {
+		return key.Provider.TagKey(uidBytes)
+	}
 	if !key.Diversified {
-		return key.KeyData
-	} else {
-		return DiversifyKey(key.KeyData, key.Application, uidBytes)
+		return key.KeyData, nil
+	}
+
+	keyBytes, err := DiversifyKeyWith(key.Variant, key.KeyData, key.Application, uidBytes)
+	if err != nil {
+		return nil, err
+	}
+	if key.Variant == DIVERSIFY_2TDEA || key.Variant == DIVERSIFY_3TDEA {
+		SetTDEAKeyVersion(keyBytes, key.KeyVersion)
 	}
+	return keyBytes, nil
 }
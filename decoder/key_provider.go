@@ -0,0 +1,188 @@
+package decoder
+
+import (
+	"container/list"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider looks up per-tag keys (e.g., random keys personalized into each
+// tag and kept in a database) by UID.  Set it as a Key's Provider to use it
+// for that key slot.  The UID is only known once the PICCData has been
+// decoded, so a KeyProvider can't be used for the MetaReadKey.
+type KeyProvider interface {
+	// TagKey gives the key for the tag with the given UID, or an error
+	// matching ErrUnknownUID if there is none.
+	TagKey(uid []byte) ([]byte, error)
+}
+
+// MapKeyProvider is a KeyProvider holding keys in memory, by lowercase hex UID.
+type MapKeyProvider map[string][]byte
+
+func (provider MapKeyProvider) TagKey(uid []byte) ([]byte, error) {
+	key, ok := provider[hex.EncodeToString(uid)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownUID, uid)
+	}
+	return key, nil
+}
+
+// LoadCSVKeyProvider reads a CSV file of per-tag keys.  See ReadCSVKeyProvider.
+func LoadCSVKeyProvider(path string, column string) (MapKeyProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCSVKeyProvider(file, column)
+}
+
+// ReadCSVKeyProvider reads per-tag keys from CSV.  The first row is a header
+// which must have a "uid" column and the given key column (so one file can
+// hold several keys for each tag, such as "uid,mac_key,file_key").  UIDs and
+// keys are in hex.
+func ReadCSVKeyProvider(reader io.Reader, column string) (MapKeyProvider, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: CSV header: %v", ErrInvalidConfig, err)
+	}
+
+	uidColumn, keyColumn := -1, -1
+	for idx, name := range header {
+		switch strings.TrimSpace(name) {
+		case "uid":
+			uidColumn = idx
+		case column:
+			keyColumn = idx
+		}
+	}
+	if uidColumn < 0 || keyColumn < 0 {
+		return nil, fmt.Errorf("%w: CSV header needs uid and %s columns", ErrInvalidConfig, column)
+	}
+
+	provider := MapKeyProvider{}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		uid, err := hex.DecodeString(record[uidColumn])
+		if err != nil || len(uid) != 7 {
+			return nil, fmt.Errorf("%w: line %d: invalid UID %q", ErrInvalidConfig, line, record[uidColumn])
+		}
+		key, err := hex.DecodeString(record[keyColumn])
+		if err != nil || len(key) != 16 {
+			return nil, fmt.Errorf("%w: line %d: invalid key for UID %s", ErrInvalidConfig, line, record[uidColumn])
+		}
+		if _, ok := provider[hex.EncodeToString(uid)]; ok {
+			return nil, fmt.Errorf("%w: line %d: duplicate UID %s", ErrInvalidConfig, line, record[uidColumn])
+		}
+		provider[hex.EncodeToString(uid)] = key
+	}
+
+	return provider, nil
+}
+
+// CachedKeyProvider caches the keys (and unknown UIDs) looked up by another
+// KeyProvider, keeping the most recently used ones.  This is safe for
+// concurrent use (if the underlying provider is).
+type CachedKeyProvider struct {
+	Provider KeyProvider
+	// Size is the maximum number of UIDs to keep.
+	Size int
+	// TTL, if set, is how long to keep a UID (so that changes to keys, and
+	// newly added tags, are seen).
+	TTL time.Duration
+	// Now gives the current time for TTL (time.Now if nil).
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// cachedKey is a key (or error) looked up by a CachedKeyProvider.
+type cachedKey struct {
+	uid       string
+	key       []byte
+	err       error
+	fetchedAt time.Time
+}
+
+// NewCachedKeyProvider creates a cache of up to size UIDs for the given provider.
+func NewCachedKeyProvider(provider KeyProvider, size int) *CachedKeyProvider {
+	return &CachedKeyProvider{
+		Provider: provider,
+		Size:     size,
+	}
+}
+
+// TagKey gives the cached key for the UID, looking it up if needed.  Only
+// keys and ErrUnknownUID are cached, not other errors (such as a failed
+// database connection).
+func (cache *CachedKeyProvider) TagKey(uid []byte) ([]byte, error) {
+	uidStr := hex.EncodeToString(uid)
+	now := cache.now()
+
+	cache.mutex.Lock()
+	if element, ok := cache.entries[uidStr]; ok {
+		entry := element.Value.(*cachedKey)
+		if cache.TTL <= 0 || now.Sub(entry.fetchedAt) < cache.TTL {
+			cache.order.MoveToFront(element)
+			cache.mutex.Unlock()
+			return entry.key, entry.err
+		}
+		cache.order.Remove(element)
+		delete(cache.entries, uidStr)
+	}
+	cache.mutex.Unlock()
+
+	key, err := cache.Provider.TagKey(uid)
+	if err != nil && !errors.Is(err, ErrUnknownUID) {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.entries == nil {
+		cache.entries = map[string]*list.Element{}
+		cache.order = list.New()
+	}
+	if element, ok := cache.entries[uidStr]; ok {
+		cache.order.Remove(element)
+	}
+	cache.entries[uidStr] = cache.order.PushFront(&cachedKey{
+		uid:       uidStr,
+		key:       key,
+		err:       err,
+		fetchedAt: now,
+	})
+	for cache.order.Len() > cache.Size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cachedKey).uid)
+	}
+
+	return key, err
+}
+
+func (cache *CachedKeyProvider) now() time.Time {
+	if cache.Now == nil {
+		return time.Now()
+	}
+	return cache.Now()
+}
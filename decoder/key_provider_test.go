@@ -0,0 +1,215 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPerTagKeyset has a shared meta read key, and per-tag MAC and file read keys.
+func testPerTagKeyset(macKeys KeyProvider, fileKeys KeyProvider) *Keyset {
+	keyset := testVerifyKeyset(AES)
+	keyset.Keys = []Key{
+		keyset.Keys[0],
+		Key{Provider: macKeys},
+		Key{Provider: fileKeys},
+	}
+	keyset.AuthenticationKey = 1
+	keyset.FileReadKey = 2
+	return keyset
+}
+
+func TestKeyProvider(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	macKeys := MapKeyProvider{}
+	fileKeys := MapKeyProvider{}
+	uids := []int64{36136180498510340, 36137992980951300}
+	for _, uid := range uids {
+		meta := Meta{Uid: uid}
+		macKey := make([]byte, 16)
+		fileKey := make([]byte, 16)
+		random.Read(macKey)
+		random.Read(fileKey)
+		macKeys[meta.UidHex()] = macKey
+		fileKeys[meta.UidHex()] = fileKey
+	}
+	keyset := testPerTagKeyset(macKeys, fileKeys)
+
+	layout := NewSDMLayout()
+	file := testVirtualTagFile("https://x.example/t?p="+strings.Repeat("0", 32)+"&e="+strings.Repeat("0", 32)+"&m="+strings.Repeat("0", 16), map[string]*int{
+		"p=": &layout.PICCDataOffset,
+		"e=": &layout.SDMENCOffset,
+		"m=": &layout.SDMMACOffset,
+	})
+	layout.SDMENCLength = 32
+	layout.SDMMACInputOffset = layout.PICCDataOffset
+
+	for _, uid := range uids {
+		tag := VirtualTag{
+			Uid:         uid,
+			ReadCounter: 1,
+			Keyset:      keyset,
+			Layout:      layout,
+			File:        file,
+			FileData:    []byte("per-tag key data"),
+			Rand:        random,
+		}
+		tapUrl, err := tag.Tap()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		result, err := keyset.VerifyLayoutURL(layout, tapUrl)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !result.Validated || result.Meta.Uid != uid || string(result.FileData) != "per-tag key data" {
+			t.Errorf("Wrong result for %d: %+v", uid, result)
+		}
+
+		// Another tag's keys don't validate it
+		swappedMacKeys := MapKeyProvider{}
+		for idx := range uids {
+			swappedMacKeys[(&Meta{Uid: uids[idx]}).UidHex()] = macKeys[(&Meta{Uid: uids[1-idx]}).UidHex()]
+		}
+		result, err = testPerTagKeyset(swappedMacKeys, fileKeys).VerifyLayoutURL(layout, tapUrl)
+		if err != nil || result.Validated {
+			t.Errorf("Validated with the wrong key (%v)", err)
+		}
+	}
+
+	// Unknown tags give an error
+	if _, err := keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3"); !errors.Is(err, ErrUnknownUID) {
+		t.Errorf("Expected unknown UID error, received %v", err)
+	}
+
+	// Per-tag keys can't be used to decode the PICCData
+	keyset.MetaReadKey = 1
+	if _, err := keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3"); !errors.Is(err, ErrNoUID) {
+		t.Errorf("Expected no UID error, received %v", err)
+	}
+}
+
+func TestReadCSVKeyProvider(t *testing.T) {
+	provider, err := ReadCSVKeyProvider(strings.NewReader("uid, mac_key, file_key\n0421272AAA6180, 000102030405060708090a0b0c0d0e0f, 0f0e0d0c0b0a09080706050403020100\n04958caa5c5e80,00000000000000000000000000000000,11111111111111111111111111111111\n"), "file_key")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	uid, _ := hex.DecodeString("0421272aaa6180")
+	key, err := provider.TagKey(uid)
+	if err != nil || hex.EncodeToString(key) != "0f0e0d0c0b0a09080706050403020100" {
+		t.Errorf("Wrong key.  Expected 0f0e0d0c0b0a09080706050403020100, received %x (%v)", key, err)
+	}
+	uid, _ = hex.DecodeString("049b112a2f7080")
+	if _, err := provider.TagKey(uid); !errors.Is(err, ErrUnknownUID) {
+		t.Errorf("Expected unknown UID error, received %v", err)
+	}
+
+	testcases := []string{
+		"",
+		"uid,mac_key\n",
+		"uid,file_key\n0421272aaa61,00000000000000000000000000000000\n",
+		"uid,file_key\n0421272aaa6180,0000\n",
+		"uid,file_key\n0421272aaa6180,00000000000000000000000000000000\n0421272AAA6180,00000000000000000000000000000000\n",
+		"uid,file_key\n0421272aaa6180\n",
+	}
+	for idx, testcase := range testcases {
+		if _, err := ReadCSVKeyProvider(strings.NewReader(testcase), "file_key"); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Testcase %d: expected invalid config error, received %v", idx, err)
+		}
+	}
+}
+
+// countingKeyProvider counts lookups, and fails them if err is set.
+type countingKeyProvider struct {
+	mutex    sync.Mutex
+	provider KeyProvider
+	lookups  int
+	err      error
+}
+
+func (provider *countingKeyProvider) TagKey(uid []byte) ([]byte, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.lookups++
+	if provider.err != nil {
+		return nil, provider.err
+	}
+	return provider.provider.TagKey(uid)
+}
+
+func TestCachedKeyProvider(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := MapKeyProvider{
+		"0421272aaa6180": []byte{1},
+		"04958caa5c5e80": []byte{2},
+		"049b112a2f7080": []byte{3},
+	}
+	counting := &countingKeyProvider{provider: keys}
+	cache := NewCachedKeyProvider(counting, 2)
+	cache.TTL = time.Minute
+	cache.Now = func() time.Time {
+		return now
+	}
+	lookup := func(uidStr string) ([]byte, error) {
+		uid, _ := hex.DecodeString(uidStr)
+		return cache.TagKey(uid)
+	}
+
+	for i := 0; i < 3; i++ {
+		if key, _ := lookup("0421272aaa6180"); len(key) != 1 || key[0] != 1 {
+			t.Errorf("Wrong key: %x", key)
+		}
+		if _, err := lookup("04000000000000"); !errors.Is(err, ErrUnknownUID) {
+			t.Errorf("Expected unknown UID error, received %v", err)
+		}
+	}
+	if counting.lookups != 2 {
+		t.Errorf("Expected 2 lookups, received %d", counting.lookups)
+	}
+
+	// The least recently used UID is dropped
+	lookup("0421272aaa6180")
+	lookup("04958caa5c5e80")
+	lookup("04000000000000")
+	if counting.lookups != 4 {
+		t.Errorf("Expected 4 lookups, received %d", counting.lookups)
+	}
+
+	// Entries expire
+	now = now.Add(time.Minute)
+	lookup("04958caa5c5e80")
+	if counting.lookups != 5 {
+		t.Errorf("Expected 5 lookups, received %d", counting.lookups)
+	}
+
+	// Other errors are not cached
+	counting.err = errors.New("database is down")
+	for i := 0; i < 2; i++ {
+		if _, err := lookup("049b112a2f7080"); err != counting.err {
+			t.Errorf("Expected database error, received %v", err)
+		}
+	}
+	if counting.lookups != 7 {
+		t.Errorf("Expected 7 lookups, received %d", counting.lookups)
+	}
+}
+
+func TestKeyProviderLegacyUnknownUID(t *testing.T) {
+	keyset := testPerTagKeyset(MapKeyProvider{}, MapKeyProvider{})
+
+	// Unknown tags must not panic in the older API
+	meta, validated := keyset.DecodeEncryptedMetaStringWithAuthenticator("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if validated || meta.Uid != 0x8061aa2a272104 {
+		t.Errorf("Wrong result: %+v (validated %v)", meta, validated)
+	}
+	if data := meta.DecryptFileData(make([]byte, 16)); data != nil {
+		t.Errorf("Expected no file data, received %x", data)
+	}
+	if data := meta.EncryptFileData(make([]byte, 16)); data != nil {
+		t.Errorf("Expected no file data, received %x", data)
+	}
+}
@@ -0,0 +1,89 @@
+package decoder
+
+import (
+	"fmt"
+)
+
+// VERSION_NONE is the version of a VersionedResult which no version validated.
+const VERSION_NONE = -1
+
+// KeysetVersion is one version of the keys used by a group of tags.
+type KeysetVersion struct {
+	Version int
+	Keyset  *Keyset
+}
+
+// KeyRotation verifies messages from tags which may have any of several
+// versions of their keys, such as while keys are being rotated.  Versions
+// are tried in order, so put the most common (usually the newest) first.
+type KeyRotation struct {
+	Versions []KeysetVersion
+}
+
+// VersionedResult is a Result along with the version of the keys which
+// validated it (or VERSION_NONE if none did).
+type VersionedResult struct {
+	Result
+	Version int
+}
+
+// Verify is like Keyset#Verify, but tries each version in turn.
+func (rotation *KeyRotation) Verify(dataStr string, authenticatorStr string) (VersionedResult, error) {
+	return rotation.Try(func(keyset *Keyset) (Result, error) {
+		return keyset.Verify(dataStr, authenticatorStr)
+	})
+}
+
+// VerifyURL is like Keyset#VerifyURL, but tries each version in turn.
+func (rotation *KeyRotation) VerifyURL(template *URLTemplate, url string) (VersionedResult, error) {
+	return rotation.Try(func(keyset *Keyset) (Result, error) {
+		return keyset.VerifyURL(template, url)
+	})
+}
+
+// VerifyLayout is like Keyset#VerifyLayout, but tries each version in turn.
+func (rotation *KeyRotation) VerifyLayout(layout SDMLayout, file []byte) (VersionedResult, error) {
+	return rotation.Try(func(keyset *Keyset) (Result, error) {
+		return keyset.VerifyLayout(layout, file)
+	})
+}
+
+// Try verifies a message with each version's keyset in turn, returning the
+// first validated Result along with its version.  Wrong keys usually fail
+// to decode the PICCData, so errors from one version do not stop the others
+// from being tried.  If no version validates the message, this gives the
+// Result of the first version which decoded it (with VERSION_NONE), or the
+// first version's error if none did.
+func (rotation *KeyRotation) Try(verify func(keyset *Keyset) (Result, error)) (VersionedResult, error) {
+	var firstErr error
+	var decoded *Result
+	for _, version := range rotation.Versions {
+		result, err := verify(version.Keyset)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if result.Validated {
+			return VersionedResult{
+				Result:  result,
+				Version: version.Version,
+			}, nil
+		}
+		if decoded == nil {
+			decoded = &result
+		}
+	}
+
+	if decoded != nil {
+		return VersionedResult{
+			Result:  *decoded,
+			Version: VERSION_NONE,
+		}, nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("%w: no keyset versions", ErrMissingKey)
+	}
+	return VersionedResult{Version: VERSION_NONE}, firstErr
+}
@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestKeyRotation(t *testing.T) {
+	zeroKeyBinary, _ := hex.DecodeString(zeroKey)
+	rotation := KeyRotation{
+		Versions: []KeysetVersion{
+			KeysetVersion{
+				Version: 2,
+				Keyset: &Keyset{
+					Mode:              AES,
+					Keys:              []Key{Key{KeyData: zeroKeyBinary}},
+					MetaReadKey:       0,
+					FileReadKey:       0,
+					AuthenticationKey: 0,
+				},
+			},
+			KeysetVersion{
+				Version: 1,
+				Keyset:  testVerifyKeyset(AES),
+			},
+		},
+	}
+
+	result, err := rotation.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated || result.Version != 1 || result.Meta.UidHex() != "0421272aaa6180" {
+		t.Errorf("Expected version 1, received %d (%+v)", result.Version, result)
+	}
+
+	template, _ := ParseURLTemplate("https://sdm.example.com/tag?picc_data={picc}&enc={macinput}{enc}&cmac={cmac}")
+	result, err = rotation.VerifyURL(template, "https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated || result.Version != 2 || string(result.FileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Expected version 2, received %d (%+v)", result.Version, result)
+	}
+
+	// A wrong MAC is not validated by any version
+	result, err = rotation.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Validated || result.Version != VERSION_NONE {
+		t.Errorf("Expected no version, received %d (%+v)", result.Version, result)
+	}
+
+	if _, err := rotation.Verify("CBF5374BC4874E7AE53961E6533DDC5Z", "C4B7E3310EFC2FA3"); !errors.Is(err, ErrBadHex) {
+		t.Errorf("Expected bad hex error, received %v", err)
+	}
+	if _, err := (&KeyRotation{}).Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3"); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Expected missing key error, received %v", err)
+	}
+}
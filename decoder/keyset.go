@@ -3,25 +3,47 @@ package decoder
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 )
 
 const KEY_NONE = -1
 
 // Keyset maintains information about a set of keys on a chip.
-// DNA chips can support 5 keys.  This does not require that the key
-// structure be mimicked, but allows for it.
+// The number of keys depends on the chip (NTAG 424 DNA chips support 5).
+// This does not require that the key structure be mimicked, but allows for it.
+// Set Chip to check the keyset against a chip family with Keyset#Validate.
+//
+// Set RandomID if the tags have Random ID enabled.  Their anticollision UID
+// is random, so the real UID is only in the encrypted PICCData (which is also
+// what the MAC session keys are derived from).  These keysets need a
+// MetaReadKey, and give ErrRandomID for plain UID and read counter mirrors.
 type Keyset struct {
 	Mode EncryptionMode
 	Keys []Key
 	MetaReadKey int 
 	FileReadKey int
 	AuthenticationKey int
+	Chip *ChipProfile
+	RandomID bool
+}
+
+// ChipProfile gives the keyset's Chip, or CHIP_NTAG424_DNA if it is not set.
+func (keyset *Keyset) ChipProfile() *ChipProfile {
+	if keyset.Chip == nil {
+		return CHIP_NTAG424_DNA
+	}
+	return keyset.Chip
+}
+
+// Validate checks that the keyset could be used with its chip (see ChipProfile#ValidateKeyset).
+func (keyset *Keyset) Validate() error {
+	return keyset.ChipProfile().ValidateKeyset(keyset)
 }
 
 // DecodeEncryptedMetaStringWithAuthenticator is a convenience function for decoding meta-only messages with meta-only MACs.
 func (keyset *Keyset) DecodeEncryptedMetaStringWithAuthenticator(dataStr string, authenticatorStr string) (meta Meta, validated bool) {
 	// Auto-turn-off encryption if it is too short
-	if len(dataStr) == 20 && keyset.MetaReadKey != KEY_NONE {
+	if len(dataStr) == 20 && keyset.MetaReadKey != KEY_NONE && !keyset.RandomID {
 		tmpKeyset := *keyset
 		tmpKeyset.MetaReadKey = KEY_NONE
 		keyset = &tmpKeyset
@@ -54,9 +76,19 @@ func (keyset *Keyset) DecodeEncryptedMetaString(dataStr string) (meta Meta) {
 
 func (keyset *Keyset) DecodeEncryptedMeta(data []byte) (meta Meta) {
 	if keyset.MetaReadKey == KEY_NONE {
+		if keyset.RandomID {
+			return Meta{
+				Keyset: keyset,
+			}
+		}
 		meta = DecodeUnencryptedBytes(data)
 	} else {
-		keyBytes := keyset.Keys[keyset.MetaReadKey].GenerateKeyBytes(nil)
+		keyBytes, err := keyset.Keys[keyset.MetaReadKey].generateKeyBytes(nil)
+		if err != nil {
+			return Meta{
+				Keyset: keyset,
+			}
+		}
 		switch keyset.Mode {
 		case AES:
 			meta = DecryptMetaAES(keyBytes, data)
@@ -67,6 +99,7 @@ func (keyset *Keyset) DecodeEncryptedMeta(data []byte) (meta Meta) {
 		default:
 			panic("Unknown Encryption Mode")
 		}
+		meta.UIDFromPICCData = keyset.RandomID && meta.HasUid
 	}
 
 	meta.Keyset = keyset
@@ -74,3 +107,113 @@ func (keyset *Keyset) DecodeEncryptedMeta(data []byte) (meta Meta) {
 	return
 }
 
+// DecodeMetaString is like DecodeEncryptedMetaString, but returns an error
+// instead of an empty Meta if the data is not valid.
+func (keyset *Keyset) DecodeMetaString(dataStr string) (Meta, error) {
+	data, err := hex.DecodeString(dataStr)
+	if err != nil {
+		return Meta{}, fmt.Errorf("%w: PICCData: %v", ErrBadHex, err)
+	}
+
+	return keyset.DecodeMeta(data)
+}
+
+// DecodeMeta is like DecodeEncryptedMeta, but returns an error instead of
+// panicking if the data or the keyset is not valid.
+func (keyset *Keyset) DecodeMeta(data []byte) (meta Meta, err error) {
+	if keyset.MetaReadKey == KEY_NONE {
+		if keyset.RandomID {
+			return Meta{}, fmt.Errorf("%w: keyset has no MetaReadKey", ErrRandomID)
+		}
+		meta, err = decodeUnencryptedMeta(data)
+	} else {
+		var keyBytes []byte
+		keyBytes, err = keyset.keyBytes(keyset.MetaReadKey, nil)
+		if err != nil {
+			return Meta{}, err
+		}
+		switch keyset.Mode {
+		case AES:
+			meta, err = decryptMetaAES(keyBytes, data)
+
+		case LRP:
+			meta, err = decryptMetaLRP(keyBytes, data)
+
+		default:
+			err = ErrUnknownMode
+		}
+		meta.UIDFromPICCData = keyset.RandomID && meta.HasUid
+	}
+	if err != nil {
+		return Meta{}, err
+	}
+
+	meta.Keyset = keyset
+
+	return meta, nil
+}
+
+// DecryptFileData is like Meta#DecryptFileData, but uses this keyset and
+// returns an error instead of panicking.
+func (keyset *Keyset) DecryptFileData(meta Meta, data []byte) ([]byte, error) {
+	if keyset.FileReadKey == KEY_NONE {
+		return data, nil
+	}
+	if err := meta.checkFileDataMirrors(); err != nil {
+		return nil, err
+	}
+	keyBytes, err := keyset.keyBytes(keyset.FileReadKey, meta.mirroredUidBytes())
+	if err != nil {
+		return nil, err
+	}
+	switch keyset.Mode {
+	case LRP:
+		counterBytes := meta.LRPFileDataCounter()
+		if err := checkLRPInput(keyBytes, counterBytes, data); err != nil {
+			return nil, err
+		}
+		sessKey := meta.GenerateLRPSessionMACKey(keyBytes)
+		return DecryptLRP(sessKey, 1, counterBytes, data), nil
+	case AES:
+		if err := checkAESInput(keyBytes, data); err != nil {
+			return nil, err
+		}
+		sessKey := meta.GenerateAESSessionENCKey(keyBytes)
+		return DecryptAESWithIV(sessKey, meta.GenerateAESFileDataIV(sessKey), data), nil
+	default:
+		return nil, ErrUnknownMode
+	}
+}
+
+// keyBytes generates the key in the given slot, checking that it
+// exists and is usable with the keyset's encryption mode.
+func (keyset *Keyset) keyBytes(slot int, uidBytes []byte) ([]byte, error) {
+	if slot < 0 || slot >= len(keyset.Keys) {
+		return nil, fmt.Errorf("%w: %d", ErrMissingKey, slot)
+	}
+	key := &keyset.Keys[slot]
+	if key.Provider != nil && len(uidBytes) == 0 {
+		return nil, fmt.Errorf("%w: per-tag key in slot %d", ErrNoUID, slot)
+	}
+	if key.Diversified && len(uidBytes) == 0 {
+		return nil, fmt.Errorf("%w: diversified key in slot %d", ErrNoUID, slot)
+	}
+	keyBytes, err := key.generateKeyBytes(uidBytes)
+	if err != nil {
+		return nil, fmt.Errorf("key in slot %d: %w", slot, err)
+	}
+	switch keyset.Mode {
+	case AES:
+		if err := checkAESInput(keyBytes, make([]byte, 16)); err != nil {
+			return nil, fmt.Errorf("key in slot %d: %w", slot, err)
+		}
+	case LRP:
+		if len(keyBytes) != 16 {
+			return nil, fmt.Errorf("%w: LRP key in slot %d is %d bytes", ErrWrongLength, slot, len(keyBytes))
+		}
+	default:
+		return nil, ErrUnknownMode
+	}
+
+	return keyBytes, nil
+}
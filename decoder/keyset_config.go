@@ -0,0 +1,648 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KEYSET_CONFIG_VERSION is the version of the keyset configuration format
+// written by MarshalKeysetConfig.
+const KEYSET_CONFIG_VERSION = 1
+
+// KeysetConfig is a named Keyset, with the URL template and/or SDMLayout of
+// the tags which use it, as stored in a keyset configuration file.
+//
+// Configuration files are YAML (or JSON, which is also YAML), like this:
+//
+//	version: 1
+//	keysets:
+//	  - name: production
+//	    chip: NTAG 424 DNA       # optional, the Name of one of the ChipProfiles
+//	    mode: AES                # AES or LRP
+//	    keys:                    # the chip's key slots (0-4 for NTAG 424 DNA)
+//	      - slot: 0
+//	        key: 00000000000000000000000000000000
+//	      - slot: 1
+//	        key: 07f23a4c407485ea3122ff242f763e77
+//	        application: 3042f562696b65646e61   # makes this a diversified key
+//	        diversification: AES128           # or AES192, 2TDEA, 3TDEA (with key_version); LEGACY if left out
+//	    meta_read_key: 0         # a slot, or none
+//	    file_read_key: 1
+//	    authentication_key: 1
+//	    random_id: false         # true if the tags use Random ID (needs meta_read_key)
+//	    url_template: https://x.example/t?p={picc}&m={cmac}
+//	    layout:                  # offsets which are left out are not mirrored
+//	      picc_data_offset: 32
+//	      mac_input_offset: 67
+//	      mac_offset: 67
+type KeysetConfig struct {
+	Name   string
+	Keyset Keyset
+	// Template and Layout are nil if they are not configured.
+	Template *URLTemplate
+	Layout   *SDMLayout
+}
+
+// KeysetConfigError is an error in a keyset configuration file, with the
+// position of the problem.  It matches ErrInvalidConfig with errors.Is.
+type KeysetConfigError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *KeysetConfigError) Error() string {
+	return fmt.Sprintf("%v: line %d, column %d: %s", ErrInvalidConfig, err.Line, err.Column, err.Message)
+}
+
+func (err *KeysetConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// keysetConfigFile is the form in which configuration files are written.
+type keysetConfigFile struct {
+	Version int                 `yaml:"version"`
+	Keysets []keysetConfigEntry `yaml:"keysets"`
+}
+
+type keysetConfigEntry struct {
+	Name              string             `yaml:"name"`
+	Chip              string             `yaml:"chip,omitempty"`
+	Mode              string             `yaml:"mode"`
+	Keys              []keyConfigEntry   `yaml:"keys"`
+	MetaReadKey       interface{}        `yaml:"meta_read_key"`
+	FileReadKey       interface{}        `yaml:"file_read_key"`
+	AuthenticationKey interface{}        `yaml:"authentication_key"`
+	RandomID          bool               `yaml:"random_id,omitempty"`
+	URLTemplate       string             `yaml:"url_template,omitempty"`
+	Layout            *layoutConfigEntry `yaml:"layout,omitempty"`
+}
+
+type keyConfigEntry struct {
+	Slot            int    `yaml:"slot"`
+	Key             string `yaml:"key"`
+	Application     string `yaml:"application,omitempty"`
+	Diversification string `yaml:"diversification,omitempty"`
+	KeyVersion      int    `yaml:"key_version,omitempty"`
+}
+
+type layoutConfigEntry struct {
+	UIDOffset         *int `yaml:"uid_offset,omitempty"`
+	SDMReadCtrOffset  *int `yaml:"read_counter_offset,omitempty"`
+	PICCDataOffset    *int `yaml:"picc_data_offset,omitempty"`
+	SDMMACInputOffset *int `yaml:"mac_input_offset,omitempty"`
+	SDMMACOffset      *int `yaml:"mac_offset,omitempty"`
+	SDMENCOffset      *int `yaml:"enc_offset,omitempty"`
+	SDMENCLength      *int `yaml:"enc_length,omitempty"`
+	TTStatusOffset    *int `yaml:"tt_status_offset,omitempty"`
+}
+
+// layoutConfigFields gives the configuration names of the layout's fields.
+func layoutConfigFields(layout *SDMLayout) []struct {
+	name  string
+	field *int
+} {
+	return []struct {
+		name  string
+		field *int
+	}{
+		{"uid_offset", &layout.UIDOffset},
+		{"read_counter_offset", &layout.SDMReadCtrOffset},
+		{"picc_data_offset", &layout.PICCDataOffset},
+		{"mac_input_offset", &layout.SDMMACInputOffset},
+		{"mac_offset", &layout.SDMMACOffset},
+		{"enc_offset", &layout.SDMENCOffset},
+		{"enc_length", &layout.SDMENCLength},
+		{"tt_status_offset", &layout.TTStatusOffset},
+	}
+}
+
+// LoadKeysetConfig reads a keyset configuration file.  See ParseKeysetConfig.
+func LoadKeysetConfig(path string) ([]KeysetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeysetConfig(data)
+}
+
+// ParseKeysetConfig parses a keyset configuration (YAML or JSON), as described
+// in KeysetConfig.  Unknown fields, missing required fields, and invalid
+// values are rejected with a KeysetConfigError giving their position.
+func ParseKeysetConfig(data []byte) ([]KeysetConfig, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, &KeysetConfigError{Line: 1, Column: 1, Message: "empty configuration"}
+	}
+
+	fields, err := configMapping(document.Content[0], "configuration", []string{"version", "keysets"}, []string{"version", "keysets"})
+	if err != nil {
+		return nil, err
+	}
+	version, err := configInt(fields["version"], "version")
+	if err != nil {
+		return nil, err
+	}
+	if version != KEYSET_CONFIG_VERSION {
+		return nil, configError(fields["version"], "unsupported version %d (expected %d)", version, KEYSET_CONFIG_VERSION)
+	}
+
+	keysetsNode := configResolve(fields["keysets"])
+	if keysetsNode.Kind != yaml.SequenceNode {
+		return nil, configError(keysetsNode, "keysets must be a list")
+	}
+	configs := []KeysetConfig{}
+	names := map[string]bool{}
+	for _, node := range keysetsNode.Content {
+		config, err := parseKeysetConfigEntry(node)
+		if err != nil {
+			return nil, err
+		}
+		if names[config.Name] {
+			return nil, configError(node, "duplicate keyset name %q", config.Name)
+		}
+		names[config.Name] = true
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+func parseKeysetConfigEntry(node *yaml.Node) (KeysetConfig, error) {
+	fields, err := configMapping(node, "keyset",
+		[]string{"name", "chip", "mode", "keys", "meta_read_key", "file_read_key", "authentication_key", "random_id", "url_template", "layout"},
+		[]string{"name", "mode", "keys"})
+	if err != nil {
+		return KeysetConfig{}, err
+	}
+
+	config := KeysetConfig{}
+	config.Name, err = configString(fields["name"], "name")
+	if err != nil {
+		return KeysetConfig{}, err
+	}
+	if config.Name == "" {
+		return KeysetConfig{}, configError(fields["name"], "name must not be empty")
+	}
+
+	mode, err := configString(fields["mode"], "mode")
+	if err != nil {
+		return KeysetConfig{}, err
+	}
+	switch mode {
+	case "AES":
+		config.Keyset.Mode = AES
+	case "LRP":
+		config.Keyset.Mode = LRP
+	default:
+		return KeysetConfig{}, configError(fields["mode"], "mode must be AES or LRP, not %q", mode)
+	}
+
+	if chipNode, ok := fields["chip"]; ok {
+		chip, err := configString(chipNode, "chip")
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		profile, ok := FindChipProfile(chip)
+		if !ok {
+			return KeysetConfig{}, configError(chipNode, "unknown chip %q", chip)
+		}
+		config.Keyset.Chip = profile
+	}
+	profile := config.Keyset.ChipProfile()
+	if !profile.SupportsMode(config.Keyset.Mode) {
+		return KeysetConfig{}, configError(fields["mode"], "%s does not support %s mode", profile.Name, mode)
+	}
+
+	keysNode := configResolve(fields["keys"])
+	if keysNode.Kind != yaml.SequenceNode {
+		return KeysetConfig{}, configError(keysNode, "keys must be a list")
+	}
+	slots := map[int]bool{}
+	for _, keyNode := range keysNode.Content {
+		slot, key, err := parseKeyConfigEntry(keyNode)
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		if !profile.ValidKey(slot) {
+			return KeysetConfig{}, configError(keyNode, "slot must be from 0 to %d, not %d", profile.KeyCount-1, slot)
+		}
+		if slots[slot] {
+			return KeysetConfig{}, configError(keyNode, "duplicate key slot %d", slot)
+		}
+		slots[slot] = true
+		for len(config.Keyset.Keys) <= slot {
+			config.Keyset.Keys = append(config.Keyset.Keys, Key{})
+		}
+		config.Keyset.Keys[slot] = key
+	}
+
+	roles := []struct {
+		name string
+		slot *int
+	}{
+		{"meta_read_key", &config.Keyset.MetaReadKey},
+		{"file_read_key", &config.Keyset.FileReadKey},
+		{"authentication_key", &config.Keyset.AuthenticationKey},
+	}
+	for _, role := range roles {
+		*role.slot = KEY_NONE
+		roleNode, ok := fields[role.name]
+		if !ok {
+			continue
+		}
+		roleNode = configResolve(roleNode)
+		if roleNode.Kind == yaml.ScalarNode && roleNode.Tag == "!!str" && roleNode.Value == "none" {
+			continue
+		}
+		slot, err := configInt(roleNode, role.name)
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		if !slots[slot] {
+			return KeysetConfig{}, configError(roleNode, "%s refers to key slot %d, which is not configured", role.name, slot)
+		}
+		*role.slot = slot
+	}
+	if config.Keyset.MetaReadKey != KEY_NONE && config.Keyset.Keys[config.Keyset.MetaReadKey].Diversified {
+		return KeysetConfig{}, configError(fields["meta_read_key"], "meta_read_key cannot be a diversified key")
+	}
+
+	if randomIDNode, ok := fields["random_id"]; ok {
+		config.Keyset.RandomID, err = configBool(randomIDNode, "random_id")
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		if config.Keyset.RandomID && config.Keyset.MetaReadKey == KEY_NONE {
+			return KeysetConfig{}, configError(randomIDNode, "random_id needs a meta_read_key")
+		}
+	}
+
+	if templateNode, ok := fields["url_template"]; ok {
+		templateStr, err := configString(templateNode, "url_template")
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		config.Template, err = ParseURLTemplate(templateStr)
+		if err != nil {
+			return KeysetConfig{}, configError(templateNode, "%v", err)
+		}
+		if config.Keyset.RandomID && config.Template.plainMirror() {
+			return KeysetConfig{}, configError(templateNode, "url_template cannot mirror the UID and read counter in plain with random_id")
+		}
+	}
+
+	if layoutNode, ok := fields["layout"]; ok {
+		layout, err := parseLayoutConfigEntry(layoutNode)
+		if err != nil {
+			return KeysetConfig{}, err
+		}
+		if config.Keyset.RandomID && layout.PICCDataOffset == OFFSET_NONE {
+			return KeysetConfig{}, configError(layoutNode, "layout needs a picc_data_offset with random_id")
+		}
+		config.Layout = &layout
+	}
+
+	return config, nil
+}
+
+func parseKeyConfigEntry(node *yaml.Node) (int, Key, error) {
+	fields, err := configMapping(node, "key", []string{"slot", "key", "application", "diversification", "key_version"}, []string{"slot", "key"})
+	if err != nil {
+		return 0, Key{}, err
+	}
+
+	slot, err := configInt(fields["slot"], "slot")
+	if err != nil {
+		return 0, Key{}, err
+	}
+
+	key := Key{}
+	key.KeyData, err = configHex(fields["key"], "key")
+	if err != nil {
+		return 0, Key{}, err
+	}
+
+	applicationNode, ok := fields["application"]
+	if !ok {
+		for _, name := range []string{"diversification", "key_version"} {
+			if _, ok := fields[name]; ok {
+				return 0, Key{}, configError(fields[name], "%s is only used for diversified keys (with an application)", name)
+			}
+		}
+		if len(key.KeyData) != 16 {
+			return 0, Key{}, configError(fields["key"], "key must be 16 bytes, not %d", len(key.KeyData))
+		}
+		return slot, key, nil
+	}
+
+	key.Diversified = true
+	key.Application, err = configHex(applicationNode, "application")
+	if err != nil {
+		return 0, Key{}, err
+	}
+	if diversificationNode, ok := fields["diversification"]; ok {
+		diversification, err := configString(diversificationNode, "diversification")
+		if err != nil {
+			return 0, Key{}, err
+		}
+		variant, ok := diversificationNames[diversification]
+		if !ok {
+			return 0, Key{}, configError(diversificationNode, "diversification must be AES128, AES192, 2TDEA, 3TDEA, or LEGACY, not %q", diversification)
+		}
+		key.Variant = variant
+	}
+	if versionNode, ok := fields["key_version"]; ok {
+		version, err := configInt(versionNode, "key_version")
+		if err != nil {
+			return 0, Key{}, err
+		}
+		if version < 0 || version > 0xFF || (key.Variant != DIVERSIFY_2TDEA && key.Variant != DIVERSIFY_3TDEA) {
+			return 0, Key{}, configError(versionNode, "key_version must be from 0 to 255, and only for TDEA keys")
+		}
+		key.KeyVersion = byte(version)
+	}
+
+	// Check the lengths with a UID of the usual length
+	if _, err := DiversifyKeyWith(key.Variant, key.KeyData, key.Application, make([]byte, 7)); err != nil {
+		return 0, Key{}, configError(node, "%v", err)
+	}
+
+	return slot, key, nil
+}
+
+// diversificationNames are the configuration names of the key diversification algorithms.
+var diversificationNames = map[string]Diversification{
+	"AES128": DIVERSIFY_AES128,
+	"AES192": DIVERSIFY_AES192,
+	"2TDEA":  DIVERSIFY_2TDEA,
+	"3TDEA":  DIVERSIFY_3TDEA,
+	"LEGACY": DIVERSIFY_LEGACY,
+}
+
+func parseLayoutConfigEntry(node *yaml.Node) (SDMLayout, error) {
+	layout := NewSDMLayout()
+	layoutFields := layoutConfigFields(&layout)
+	names := []string{}
+	for _, layoutField := range layoutFields {
+		names = append(names, layoutField.name)
+	}
+
+	fields, err := configMapping(node, "layout", names, nil)
+	if err != nil {
+		return SDMLayout{}, err
+	}
+	for _, layoutField := range layoutFields {
+		fieldNode, ok := fields[layoutField.name]
+		if !ok {
+			continue
+		}
+		value, err := configInt(fieldNode, layoutField.name)
+		if err != nil {
+			return SDMLayout{}, err
+		}
+		if value < 0 || value > 0xFFFFFF {
+			return SDMLayout{}, configError(fieldNode, "%s must be from 0 to 16777215, not %d", layoutField.name, value)
+		}
+		*layoutField.field = value
+	}
+	if err := checkLayoutConfig(node, layout, fields); err != nil {
+		return SDMLayout{}, err
+	}
+
+	return layout, nil
+}
+
+// checkLayoutConfig cross-checks the offsets of a layout, as the chip does
+// when its file settings are changed, so that a bad layout is reported with
+// its line rather than failing every verification.
+func checkLayoutConfig(node *yaml.Node, layout SDMLayout, fields map[string]*yaml.Node) error {
+	fieldNode := func(name string) *yaml.Node {
+		if fieldNode, ok := fields[name]; ok {
+			return fieldNode
+		}
+		return node
+	}
+
+	if layout.PICCDataOffset != OFFSET_NONE && (layout.UIDOffset != OFFSET_NONE || layout.SDMReadCtrOffset != OFFSET_NONE) {
+		return configError(fieldNode("picc_data_offset"), "picc_data_offset can't be used with uid_offset or read_counter_offset")
+	}
+	if (layout.SDMMACInputOffset == OFFSET_NONE) != (layout.SDMMACOffset == OFFSET_NONE) {
+		return configError(node, "mac_input_offset and mac_offset must be given together")
+	}
+	if layout.SDMMACInputOffset > layout.SDMMACOffset {
+		return configError(fieldNode("mac_input_offset"), "mac_input_offset %d is after mac_offset %d", layout.SDMMACInputOffset, layout.SDMMACOffset)
+	}
+	if (layout.SDMENCOffset == OFFSET_NONE) != (layout.SDMENCLength == OFFSET_NONE) {
+		return configError(node, "enc_offset and enc_length must be given together")
+	}
+	if layout.SDMENCOffset != OFFSET_NONE {
+		if layout.SDMENCLength == 0 || layout.SDMENCLength%32 != 0 {
+			return configError(fieldNode("enc_length"), "enc_length must be a multiple of 32, not %d", layout.SDMENCLength)
+		}
+		if layout.SDMMACOffset == OFFSET_NONE || layout.SDMENCOffset < layout.SDMMACInputOffset || layout.SDMENCOffset+layout.SDMENCLength > layout.SDMMACOffset {
+			return configError(fieldNode("enc_offset"), "encrypted file data must be between mac_input_offset and mac_offset")
+		}
+	}
+	return nil
+}
+
+// MarshalKeysetConfig writes keysets in the configuration format read by
+// ParseKeysetConfig (as YAML).  Keysets which could not be read back, such as
+// ones with more keys than their chip, diversified keys without application
+// data, or keys from a Provider, are rejected.
+func MarshalKeysetConfig(configs []KeysetConfig) ([]byte, error) {
+	file := keysetConfigFile{
+		Version: KEYSET_CONFIG_VERSION,
+		Keysets: []keysetConfigEntry{},
+	}
+	for _, config := range configs {
+		entry := keysetConfigEntry{
+			Name: config.Name,
+			Keys: []keyConfigEntry{},
+		}
+		if config.Keyset.Chip != nil {
+			entry.Chip = config.Keyset.Chip.Name
+		}
+		switch config.Keyset.Mode {
+		case AES:
+			entry.Mode = "AES"
+		case LRP:
+			entry.Mode = "LRP"
+		default:
+			return nil, fmt.Errorf("%w: keyset %q", ErrUnknownMode, config.Name)
+		}
+
+		for slot, key := range config.Keyset.Keys {
+			if key.Provider != nil {
+				return nil, fmt.Errorf("%w: keyset %q slot %d: per-tag key providers can't be written", ErrInvalidConfig, config.Name, slot)
+			}
+			if len(key.KeyData) == 0 {
+				continue
+			}
+			if key.Diversified && len(key.Application) == 0 {
+				return nil, fmt.Errorf("%w: keyset %q slot %d: diversified keys need application data", ErrInvalidConfig, config.Name, slot)
+			}
+			keyEntry := keyConfigEntry{
+				Slot: slot,
+				Key:  hex.EncodeToString(key.KeyData),
+			}
+			if key.Diversified {
+				keyEntry.Application = hex.EncodeToString(key.Application)
+				for name, variant := range diversificationNames {
+					if variant == key.Variant && variant != DIVERSIFY_LEGACY {
+						keyEntry.Diversification = name
+					}
+				}
+				keyEntry.KeyVersion = int(key.KeyVersion)
+			}
+			entry.Keys = append(entry.Keys, keyEntry)
+		}
+
+		entry.MetaReadKey = configRole(config.Keyset.MetaReadKey)
+		entry.FileReadKey = configRole(config.Keyset.FileReadKey)
+		entry.AuthenticationKey = configRole(config.Keyset.AuthenticationKey)
+		entry.RandomID = config.Keyset.RandomID
+
+		if config.Template != nil {
+			entry.URLTemplate = config.Template.Template
+		}
+		if config.Layout != nil {
+			layout := *config.Layout
+			entry.Layout = &layoutConfigEntry{}
+			entryFields := []**int{
+				&entry.Layout.UIDOffset,
+				&entry.Layout.SDMReadCtrOffset,
+				&entry.Layout.PICCDataOffset,
+				&entry.Layout.SDMMACInputOffset,
+				&entry.Layout.SDMMACOffset,
+				&entry.Layout.SDMENCOffset,
+				&entry.Layout.SDMENCLength,
+				&entry.Layout.TTStatusOffset,
+			}
+			for idx, layoutField := range layoutConfigFields(&layout) {
+				if *layoutField.field != OFFSET_NONE {
+					*entryFields[idx] = layoutField.field
+				}
+			}
+		}
+
+		file.Keysets = append(file.Keysets, entry)
+	}
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseKeysetConfig(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// configRole gives the configuration value for a key role.
+func configRole(slot int) interface{} {
+	if slot == KEY_NONE {
+		return "none"
+	}
+	return slot
+}
+
+func configError(node *yaml.Node, format string, args ...interface{}) error {
+	return &KeysetConfigError{
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// configResolve follows YAML aliases.
+func configResolve(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// configMapping gives the fields of a mapping, rejecting unknown, duplicate,
+// and missing required fields.
+func configMapping(node *yaml.Node, name string, allowed []string, required []string) (map[string]*yaml.Node, error) {
+	node = configResolve(node)
+	if node.Kind != yaml.MappingNode {
+		return nil, configError(node, "%s must be a mapping", name)
+	}
+
+	fields := map[string]*yaml.Node{}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		keyNode, valueNode := node.Content[idx], node.Content[idx+1]
+		known := false
+		for _, field := range allowed {
+			if keyNode.Value == field {
+				known = true
+			}
+		}
+		if !known {
+			return nil, configError(keyNode, "unknown field %q in %s (expected one of %s)", keyNode.Value, name, strings.Join(allowed, ", "))
+		}
+		if _, ok := fields[keyNode.Value]; ok {
+			return nil, configError(keyNode, "duplicate field %q in %s", keyNode.Value, name)
+		}
+		fields[keyNode.Value] = valueNode
+	}
+
+	for _, field := range required {
+		if _, ok := fields[field]; !ok {
+			return nil, configError(node, "%s is missing required field %q", name, field)
+		}
+	}
+
+	return fields, nil
+}
+
+func configInt(node *yaml.Node, name string) (int, error) {
+	node = configResolve(node)
+	var value int
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!int" || node.Decode(&value) != nil {
+		return 0, configError(node, "%s must be an integer", name)
+	}
+	return value, nil
+}
+
+func configBool(node *yaml.Node, name string) (bool, error) {
+	node = configResolve(node)
+	var value bool
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" || node.Decode(&value) != nil {
+		return false, configError(node, "%s must be true or false", name)
+	}
+	return value, nil
+}
+
+func configString(node *yaml.Node, name string) (string, error) {
+	node = configResolve(node)
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		return "", configError(node, "%s must be a string", name)
+	}
+	return node.Value, nil
+}
+
+// configHex decodes a hex string.  Unquoted hex which happens to look like a
+// number is also accepted, since YAML would otherwise read keys like
+// 00000000000000000000000000000000 as integers.
+func configHex(node *yaml.Node, name string) ([]byte, error) {
+	node = configResolve(node)
+	if node.Kind != yaml.ScalarNode || (node.Tag != "!!str" && node.Tag != "!!int" && node.Tag != "!!float") {
+		return nil, configError(node, "%s must be a hex string", name)
+	}
+	data, err := hex.DecodeString(node.Value)
+	if err != nil {
+		return nil, configError(node, "%s is not valid hex: %v", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,244 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testKeysetConfig = `version: 1
+keysets:
+  - name: production
+    mode: AES
+    keys:
+      - slot: 0
+        key: e6cbb56d350c25eda052b27f81b1c884
+      - slot: 1
+        key: 07f23a4c407485ea3122ff242f763e77
+        application: 3042f562696b65646e61
+    meta_read_key: 0
+    file_read_key: 0
+    authentication_key: 1
+    url_template: https://x.example/t?p={picc}&m={cmac}
+  - name: sdm-backend
+    mode: LRP
+    keys:
+      - slot: 2
+        key: 00000000000000000000000000000000
+    meta_read_key: 2
+    file_read_key: 2
+    authentication_key: 2
+    layout:
+      picc_data_offset: 37
+      mac_input_offset: 90
+      mac_offset: 128
+      enc_offset: 90
+      enc_length: 32
+`
+
+func TestParseKeysetConfig(t *testing.T) {
+	configs, err := ParseKeysetConfig([]byte(testKeysetConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 keysets, received %d", len(configs))
+	}
+
+	production := configs[0]
+	if production.Name != "production" || production.Layout != nil || production.Template == nil {
+		t.Errorf("Wrong keyset: %+v", production)
+	}
+	if !reflect.DeepEqual(production.Keyset, *testVerifyKeyset(AES)) {
+		t.Errorf("Wrong keyset.  Expected %+v, received %+v", *testVerifyKeyset(AES), production.Keyset)
+	}
+	result, err := production.Keyset.VerifyURL(production.Template, "https://x.example/t?p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3")
+	if err != nil || !result.Validated {
+		t.Errorf("Not validated (%v)", err)
+	}
+
+	backend := configs[1]
+	if backend.Keyset.Mode != LRP || len(backend.Keyset.Keys) != 3 || backend.Keyset.MetaReadKey != 2 || backend.Template != nil {
+		t.Errorf("Wrong keyset: %+v", backend)
+	}
+	expectedLayout := NewSDMLayout()
+	expectedLayout.PICCDataOffset = 37
+	expectedLayout.SDMMACInputOffset = 90
+	expectedLayout.SDMMACOffset = 128
+	expectedLayout.SDMENCOffset = 90
+	expectedLayout.SDMENCLength = 32
+	if backend.Layout == nil || *backend.Layout != expectedLayout {
+		t.Errorf("Wrong layout: %+v", backend.Layout)
+	}
+	result, err = backend.Keyset.VerifyLayoutURL(*backend.Layout, "https://sdm.example.com/tag?picc_data=07D9CA2545881D4BFDD920BE1603268C0714420DD893A497&enc=D6E921C47DB4C17C56F979F81559BB83&cmac=F9481AC7D855BDB6")
+	if err != nil || !result.Validated || string(result.FileData) != "NTXXb7dz3PsYYBlU" {
+		t.Errorf("Not validated (%v)", err)
+	}
+}
+
+func TestParseKeysetConfigJSON(t *testing.T) {
+	configs, err := ParseKeysetConfig([]byte(`{
+  "version": 1,
+  "keysets": [
+    {
+      "name": "json",
+      "mode": "AES",
+      "keys": [{"slot": 0, "key": "00000000000000000000000000000000"}],
+      "authentication_key": 0
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if configs[0].Keyset.AuthenticationKey != 0 || configs[0].Keyset.MetaReadKey != KEY_NONE || configs[0].Keyset.FileReadKey != KEY_NONE {
+		t.Errorf("Wrong keyset: %+v", configs[0].Keyset)
+	}
+}
+
+func TestParseKeysetConfigErrors(t *testing.T) {
+	testcases := []struct {
+		config string
+		line   int
+	}{
+		{"version: 2\nkeysets: []\n", 1},
+		{"keysets: []\n", 1},
+		{"version: 1\nkeysets: []\nextra: 1\n", 3},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: DES\n    keys: []\n", 4},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 5\n        key: 00000000000000000000000000000000\n", 6},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        key: 000000\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        key: zz\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        key: 00000000000000000000000000000000\n      - slot: 0\n        key: 00000000000000000000000000000000\n", 8},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    meta_read_key: 1\n", 6},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        key: 00000000000000000000000000000000\n        application: 01\n    meta_read_key: 0\n", 9},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    url_template: https://x.example/{picc}\n", 6},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      mac_offset: -1\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      macoffset: 1\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      picc_data_offset: 10\n      mac_offset: 60\n      mac_input_offset: 70\n", 9},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      picc_data_offset: 10\n      mac_offset: 60\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      picc_data_offset: 10\n      uid_offset: 50\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      mac_input_offset: 50\n      mac_offset: 100\n      enc_offset: 50\n      enc_length: 20\n", 10},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      mac_input_offset: 50\n      mac_offset: 100\n      enc_offset: 40\n      enc_length: 32\n", 9},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    layout:\n      mac_input_offset: 50\n      mac_offset: 100\n      enc_offset: 50\n", 7},
+		{"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n  - name: a\n    mode: AES\n    keys: []\n", 6},
+		{"version: 1\nkeysets:\n  - name: a\n    chip: NTAG 213\n    mode: AES\n    keys: []\n", 4},
+		{"version: 1\nkeysets:\n  - name: a\n    chip: MIFARE DESFire EV3\n    mode: LRP\n    keys: []\n", 5},
+		{"version: 1\nkeysets:\n  - name: a\n    chip: MIFARE DESFire EV3\n    mode: AES\n    keys:\n      - slot: 14\n        key: 00000000000000000000000000000000\n", 7},
+	}
+	for idx, testcase := range testcases {
+		_, err := ParseKeysetConfig([]byte(testcase.config))
+		var configErr *KeysetConfigError
+		if !errors.As(err, &configErr) || !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Testcase %d: expected a configuration error, received %v", idx, err)
+			continue
+		}
+		if configErr.Line != testcase.line {
+			t.Errorf("Testcase %d: wrong line.  Expected %d, received %d (%v)", idx, testcase.line, configErr.Line, err)
+		}
+	}
+
+	if _, err := ParseKeysetConfig([]byte("version: [1\n")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a configuration error, received %v", err)
+	}
+	if _, err := ParseKeysetConfig([]byte("")); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a configuration error, received %v", err)
+	}
+}
+
+func TestMarshalKeysetConfig(t *testing.T) {
+	configs, _ := ParseKeysetConfig([]byte(testKeysetConfig))
+	data, err := MarshalKeysetConfig(configs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reread, err := ParseKeysetConfig(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(configs, reread) {
+		t.Errorf("Keysets changed when written.  Expected %+v, received %+v", configs, reread)
+	}
+
+	tooMany := KeysetConfig{
+		Name: "too many",
+		Keyset: Keyset{
+			Mode:              AES,
+			Keys:              make([]Key, 6),
+			MetaReadKey:       KEY_NONE,
+			FileReadKey:       KEY_NONE,
+			AuthenticationKey: KEY_NONE,
+		},
+	}
+	tooMany.Keyset.Keys[5].KeyData = make([]byte, 16)
+	if _, err := MarshalKeysetConfig([]KeysetConfig{tooMany}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a configuration error, received %v", err)
+	}
+
+	// Neither of these would read back as the same key
+	noApplication := configs[0]
+	noApplication.Keyset.Keys = []Key{{KeyData: make([]byte, 16), Diversified: true}}
+	noApplication.Keyset.AuthenticationKey = 0
+	if _, err := MarshalKeysetConfig([]KeysetConfig{noApplication}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a configuration error for a diversified key without application data, received %v", err)
+	}
+	provider := configs[0]
+	provider.Keyset.Keys = []Key{{Provider: MapKeyProvider{}}}
+	provider.Keyset.AuthenticationKey = 0
+	if _, err := MarshalKeysetConfig([]KeysetConfig{provider}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected a configuration error for a key provider, received %v", err)
+	}
+}
+
+func TestKeysetConfigDiversification(t *testing.T) {
+	configs, err := ParseKeysetConfig([]byte(`version: 1
+keysets:
+  - name: desfire
+    mode: AES
+    keys:
+      - slot: 0
+        key: 00112233445566778899AABBCCDDEEFF0102030405060708
+        application: 3042F54E5850
+        diversification: 3TDEA
+        key_version: 0x55
+      - slot: 1
+        key: 00112233445566778899AABBCCDDEEFF
+        application: 3042F54E
+        diversification: LEGACY
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	chipUid, _ := hex.DecodeString("04782E21801D80")
+	key := configs[0].Keyset.Keys[0]
+	if keyBytes := hex.EncodeToString(key.GenerateKeyBytes(chipUid)); keyBytes != "2e0dd03774d3fa9b5705ab0bda91ca0b55b8e07fcdbf10ec" {
+		t.Errorf("Wrong key.  Expected 2e0dd03774d3fa9b5705ab0bda91ca0b55b8e07fcdbf10ec, received %s", keyBytes)
+	}
+	legacy := configs[0].Keyset.Keys[1]
+	expected := DiversifyKey(legacy.KeyData, legacy.Application, chipUid)
+	if keyBytes := legacy.GenerateKeyBytes(chipUid); !reflect.DeepEqual(keyBytes, expected) {
+		t.Errorf("Wrong legacy key.  Expected %x, received %x", expected, keyBytes)
+	}
+
+	data, err := MarshalKeysetConfig(configs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reread, err := ParseKeysetConfig(data)
+	if err != nil || !reflect.DeepEqual(configs, reread) {
+		t.Errorf("Keysets changed when written (%v):\n%s", err, data)
+	}
+
+	for idx, keyConfig := range []string{
+		"key: 00112233445566778899AABBCCDDEEFF0102030405060708\n        application: 3042F54E5850",
+		"key: 00112233445566778899AABBCCDDEEFF\n        application: 3042F54E5850\n        diversification: DES",
+		"key: 00112233445566778899AABBCCDDEEFF\n        application: 3042F54E5850\n        key_version: 1",
+		"key: 00112233445566778899AABBCCDDEEFF\n        diversification: AES128",
+		"key: 00112233445566778899AABBCCDDEEFF\n        application: 3042F54E58503042F54E58503042F54E58503042F54E585030\n        diversification: AES128",
+	} {
+		_, err := ParseKeysetConfig([]byte("version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        " + keyConfig + "\n"))
+		if !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Testcase %d: expected a configuration error, received %v", idx, err)
+		}
+	}
+}
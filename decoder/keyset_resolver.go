@@ -0,0 +1,313 @@
+package decoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/johnnyb/gocrypto/lrp"
+)
+
+// KeysetResolver finds the keyset for a message when keysets are hosted for
+// several tenants (e.g., customers), and verifies the message with it.
+type KeysetResolver interface {
+	// ResolveURL verifies a full tap URL.
+	ResolveURL(url string) (Resolution, error)
+	// ResolvePICCData verifies PICCData and a MAC (over an empty string), as with Keyset#Verify.
+	ResolvePICCData(dataStr string, authenticatorStr string) (Resolution, error)
+}
+
+// Tenant is a tenant's keyset.  Template is the URL template of its tags, if known.
+type Tenant struct {
+	ID       string
+	Keyset   *Keyset
+	Template *URLTemplate
+}
+
+// Resolution is the tenant found for a message, and the Result of verifying it
+// with the tenant's keyset.
+type Resolution struct {
+	Result
+	TenantID string
+	Keyset   *Keyset
+}
+
+// TenantResolver is a KeysetResolver for a fixed set of tenants.  Tenants
+// are chosen by matching their URL templates (which usually differ by host
+// or path).  If several tenants' templates match, none does (in which case
+// every tenant is a candidate, and the PICCData and MAC are found by their
+// lengths), or only the PICCData is given, the PICCData is decrypted with
+// each candidate tenant's MetaReadKey,
+// and only tenants for which it gives a valid PICCDataTag are checked
+// further.  If several are left, the MAC decides.  The MetaReadKeys are
+// prepared when the resolver is created, so this is cheap even with hundreds
+// of tenants.
+//
+// Tenants whose PICCData is not encrypted cannot be ruled out this way, so
+// plain UID and read counter mirrors are checked against the MAC of each.
+type TenantResolver struct {
+	tenants []*resolverTenant
+}
+
+type resolverTenant struct {
+	Tenant
+	decrypter *piccDataDecrypter
+}
+
+// piccDataDecrypter decrypts PICCData with a MetaReadKey which has been
+// prepared in advance.  It is safe for concurrent use.
+type piccDataDecrypter struct {
+	mode      EncryptionMode
+	block     cipher.Block
+	lrpCipher *lrp.LrpCipher
+}
+
+// NewTenantResolver creates a TenantResolver.  Tenants are tried in order.
+func NewTenantResolver(tenants []Tenant) (*TenantResolver, error) {
+	resolver := &TenantResolver{}
+	ids := map[string]bool{}
+	for _, tenant := range tenants {
+		if ids[tenant.ID] {
+			return nil, fmt.Errorf("%w: duplicate tenant %q", ErrInvalidConfig, tenant.ID)
+		}
+		ids[tenant.ID] = true
+		if tenant.Keyset == nil {
+			return nil, fmt.Errorf("%w: tenant %q has no keyset", ErrMissingKey, tenant.ID)
+		}
+
+		entry := &resolverTenant{
+			Tenant: tenant,
+		}
+		if tenant.Keyset.MetaReadKey != KEY_NONE {
+			decrypter, err := newPICCDataDecrypter(tenant.Keyset)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", tenant.ID, err)
+			}
+			entry.decrypter = decrypter
+		}
+		resolver.tenants = append(resolver.tenants, entry)
+	}
+	return resolver, nil
+}
+
+func newPICCDataDecrypter(keyset *Keyset) (*piccDataDecrypter, error) {
+	key, err := keyset.keyBytes(keyset.MetaReadKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter := &piccDataDecrypter{
+		mode: keyset.Mode,
+	}
+	switch keyset.Mode {
+	case AES:
+		decrypter.block, err = aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrWrongLength, err)
+		}
+	case LRP:
+		decrypter.lrpCipher = lrp.NewStandardMultiCipher(key).Cipher(0)
+	default:
+		return nil, ErrUnknownMode
+	}
+	return decrypter, nil
+}
+
+// decrypt decrypts and parses PICCData, as with Keyset#DecodeMeta.
+func (decrypter *piccDataDecrypter) decrypt(data []byte) (Meta, error) {
+	switch decrypter.mode {
+	case AES:
+		if len(data) != 16 {
+			return Meta{}, fmt.Errorf("%w: AES PICCData is %d bytes, expected 16", ErrWrongLength, len(data))
+		}
+		// CBC with a zero IV is the same as ECB for a single block
+		plaintext := make([]byte, 16)
+		decrypter.block.Decrypt(plaintext, data)
+		return ParsePICCData(plaintext)
+
+	default:
+		if len(data) != 24 {
+			return Meta{}, fmt.Errorf("%w: LRP PICCData is %d bytes, expected 24", ErrWrongLength, len(data))
+		}
+		lrpCipher := *decrypter.lrpCipher
+		lrpCipher.Counter = 0
+		for _, b := range data[0:8] {
+			lrpCipher.Counter = (lrpCipher.Counter << 8) | uint64(b)
+		}
+		lrpCipher.CounterSize = 16
+		return ParsePICCData(lrpCipher.DecryptAll(data[8:24], false))
+	}
+}
+
+// decode decodes the PICCData with the tenant's prepared MetaReadKey, telling
+// whether it could be from one of the tenant's tags.  The Meta is nil if the
+// PICCData is not encrypted (so it must be decoded by the keyset).
+func (tenant *resolverTenant) decode(dataStr string) (*Meta, bool) {
+	if tenant.decrypter == nil || len(dataStr) == 20 {
+		return nil, true
+	}
+	data, err := hex.DecodeString(dataStr)
+	if err != nil {
+		return nil, false
+	}
+	meta, err := tenant.decrypter.decrypt(data)
+	if err != nil {
+		return nil, false
+	}
+	meta.UIDFromPICCData = tenant.Keyset.RandomID && meta.HasUid
+	meta.Keyset = tenant.Keyset
+	return &meta, true
+}
+
+func (resolver *TenantResolver) ResolveURL(url string) (Resolution, error) {
+	candidates := []*resolverTenant{}
+	for _, tenant := range resolver.tenants {
+		if tenant.Template == nil {
+			continue
+		}
+		if _, err := tenant.Template.Match(url); err == nil {
+			candidates = append(candidates, tenant)
+		}
+	}
+	if len(candidates) == 0 {
+		return resolver.resolveUnmatchedURL(url)
+	}
+	if len(candidates) == 1 {
+		return candidates[0].resolution(candidates[0].Keyset.VerifyURL(candidates[0].Template, url))
+	}
+
+	return resolve(candidates, func(tenant *resolverTenant) string {
+		components, _ := tenant.Template.Match(url)
+		if components.PICCData == "" {
+			return components.UID + components.ReadCounter
+		}
+		return components.PICCData
+	}, func(tenant *resolverTenant, meta *Meta) (Result, error) {
+		if meta == nil {
+			return tenant.Keyset.VerifyURL(tenant.Template, url)
+		}
+		components, _ := tenant.Template.Match(url)
+		return tenant.Keyset.verifyURLMeta(components, url, *meta)
+	})
+}
+
+// resolveUnmatchedURL tries every tenant on a URL which no template matches.
+// Without a template, the SUN message is found by its hex fields: the
+// PICCData is the first field of the tenant's PICCData length (or a plain
+// UID and read counter), and the MAC is the next 16-character field.  The MAC is checked over an empty input, as with Keyset#Verify, so
+// messages with a longer MAC input can be resolved but are not Validated.
+func (resolver *TenantResolver) resolveUnmatchedURL(url string) (Resolution, error) {
+	fields := urlHexFields(url)
+	return resolve(resolver.tenants, func(tenant *resolverTenant) string {
+		piccData, _ := findURLMessage(fields, tenant.Keyset.PICCDataLength())
+		return piccData
+	}, func(tenant *resolverTenant, meta *Meta) (Result, error) {
+		piccData, mac := findURLMessage(fields, tenant.Keyset.PICCDataLength())
+		if piccData == "" {
+			return Result{}, fmt.Errorf("%w: no PICCData in the URL", ErrWrongLength)
+		}
+		if meta == nil {
+			return tenant.Keyset.Verify(piccData, mac)
+		}
+		return verifyMeta(*meta, mac)
+	})
+}
+
+// urlHexFields splits a URL into its path segments and query names and
+// values, giving the ones which are hex.
+func urlHexFields(url string) []string {
+	fields := []string{}
+	for _, field := range strings.FieldsFunc(url, func(r rune) bool {
+		return strings.ContainsRune("/?&=#;:.", r)
+	}) {
+		if _, err := hex.DecodeString(field); err == nil {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// findURLMessage finds the PICCData (of the given length, or a plain UID and
+// read counter, which may be separate fields) and the MAC among a URL's hex
+// fields.
+func findURLMessage(fields []string, piccDataLength int) (piccData string, mac string) {
+	for idx, field := range fields {
+		next := idx + 1
+		if len(field) == piccDataLength || len(field) == 20 {
+			piccData = field
+		} else if len(field) == 14 && next < len(fields) && len(fields[next]) == 6 {
+			piccData = field + fields[next]
+			next++
+		} else {
+			continue
+		}
+		for _, macField := range fields[next:] {
+			if len(macField) == 16 {
+				return piccData, macField
+			}
+		}
+		return piccData, ""
+	}
+	return "", ""
+}
+
+func (resolver *TenantResolver) ResolvePICCData(dataStr string, authenticatorStr string) (Resolution, error) {
+	if _, err := hex.DecodeString(dataStr); err != nil {
+		return Resolution{}, fmt.Errorf("%w: PICCData: %v", ErrBadHex, err)
+	}
+	return resolve(resolver.tenants, func(tenant *resolverTenant) string {
+		return dataStr
+	}, func(tenant *resolverTenant, meta *Meta) (Result, error) {
+		if meta == nil {
+			return tenant.Keyset.Verify(dataStr, authenticatorStr)
+		}
+		return verifyMeta(*meta, authenticatorStr)
+	})
+}
+
+// resolve verifies the message with each plausible candidate, giving the first
+// which validates it.  If none does, but only one could decode it, that one is
+// given (with a Result which is not Validated).  PICCData decoded while checking
+// plausibility is passed on to verify, so it is only decrypted once.
+func resolve(candidates []*resolverTenant, piccData func(tenant *resolverTenant) string, verify func(tenant *resolverTenant, meta *Meta) (Result, error)) (Resolution, error) {
+	var decoded *Resolution
+	decodedCount := 0
+	for _, tenant := range candidates {
+		meta, plausible := tenant.decode(piccData(tenant))
+		if !plausible {
+			continue
+		}
+		resolution, err := tenant.resolution(verify(tenant, meta))
+		if err != nil {
+			continue
+		}
+		if resolution.Validated {
+			return resolution, nil
+		}
+		decodedCount++
+		if decoded == nil {
+			decoded = &resolution
+		}
+	}
+
+	if decodedCount == 1 {
+		return *decoded, nil
+	}
+	if decodedCount > 1 {
+		return Resolution{}, fmt.Errorf("%w: message decodes for %d tenants, but none validated it", ErrUnknownTenant, decodedCount)
+	}
+	return Resolution{}, fmt.Errorf("%w: message does not decode for any tenant", ErrUnknownTenant)
+}
+
+func (tenant *resolverTenant) resolution(result Result, err error) (Resolution, error) {
+	if err != nil {
+		return Resolution{}, err
+	}
+	return Resolution{
+		Result:   result,
+		TenantID: tenant.ID,
+		Keyset:   tenant.Keyset,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"testing"
+)
+
+func testResolverTenants(fillers int) []Tenant {
+	zeroKeyBinary, _ := hex.DecodeString(zeroKey)
+	sdmTemplate, _ := ParseURLTemplate("https://sdm.example.com/tag?picc_data={picc}&enc={macinput}{enc}&cmac={cmac}")
+	acmeTemplate, _ := ParseURLTemplate("https://acme.example/t?p={picc}&m={cmac}")
+
+	tenants := []Tenant{}
+	random := mathrand.New(mathrand.NewSource(1))
+	for idx := 0; idx < fillers; idx++ {
+		key := make([]byte, 16)
+		random.Read(key)
+		mode := AES
+		if idx%2 == 1 {
+			mode = LRP
+		}
+		tenants = append(tenants, Tenant{
+			ID: fmt.Sprintf("filler-%d", idx),
+			Keyset: &Keyset{
+				Mode:              mode,
+				Keys:              []Key{Key{KeyData: key}},
+				MetaReadKey:       0,
+				FileReadKey:       0,
+				AuthenticationKey: 0,
+			},
+		})
+	}
+
+	for _, mode := range []EncryptionMode{AES, LRP} {
+		tenants = append(tenants, Tenant{
+			ID: fmt.Sprintf("zero-%d", mode),
+			Keyset: &Keyset{
+				Mode:              mode,
+				Keys:              []Key{Key{KeyData: zeroKeyBinary}},
+				MetaReadKey:       0,
+				FileReadKey:       0,
+				AuthenticationKey: 0,
+			},
+			Template: sdmTemplate,
+		})
+	}
+	return append(tenants, Tenant{
+		ID:       "acme",
+		Keyset:   testVerifyKeyset(AES),
+		Template: acmeTemplate,
+	})
+}
+
+func TestTenantResolver(t *testing.T) {
+	resolver, err := NewTenantResolver(testResolverTenants(200))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testcases := []struct {
+		url      string
+		tenantID string
+		uid      string
+	}{
+		{"https://acme.example/t?p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3", "acme", "0421272aaa6180"},
+		// Both zero-key tenants share a template, so the PICCData decides
+		{"https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6", fmt.Sprintf("zero-%d", AES), "04958caa5c5e80"},
+		{"https://sdm.example.com/tag?picc_data=07D9CA2545881D4BFDD920BE1603268C0714420DD893A497&enc=D6E921C47DB4C17C56F979F81559BB83&cmac=F9481AC7D855BDB6", fmt.Sprintf("zero-%d", LRP), "049b112a2f7080"},
+	}
+	for idx, testcase := range testcases {
+		resolution, err := resolver.ResolveURL(testcase.url)
+		if err != nil {
+			t.Errorf("Testcase %d: unexpected error: %v", idx, err)
+			continue
+		}
+		if resolution.TenantID != testcase.tenantID || !resolution.Validated || resolution.Meta.UidHex() != testcase.uid {
+			t.Errorf("Testcase %d: wrong tenant.  Expected %s, received %s (%+v)", idx, testcase.tenantID, resolution.TenantID, resolution.Result)
+		}
+	}
+
+	// Without a URL, every tenant is tried
+	resolution, err := resolver.ResolvePICCData("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if err != nil || resolution.TenantID != "acme" || !resolution.Validated {
+		t.Errorf("Wrong tenant.  Expected acme, received %s (%v)", resolution.TenantID, err)
+	}
+	resolution, err = resolver.ResolvePICCData("0471862A506380000003", "637618472FE7D110")
+	if err != nil || resolution.TenantID != "acme" || !resolution.Validated {
+		t.Errorf("Wrong tenant.  Expected acme, received %s (%v)", resolution.TenantID, err)
+	}
+
+	// If no template matches, every tenant is tried
+	unmatched := []struct {
+		url      string
+		tenantID string
+	}{
+		{"https://other.example/t?p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3", "acme"},
+		{"https://other.example/CBF5374BC4874E7AE53961E6533DDC5F/C4B7E3310EFC2FA3", "acme"},
+		{"https://other.example/t?uid=0471862A506380&ctr=000003&cmac=637618472FE7D110", "acme"},
+	}
+	for _, testcase := range unmatched {
+		resolution, err := resolver.ResolveURL(testcase.url)
+		if err != nil || resolution.TenantID != testcase.tenantID || !resolution.Validated {
+			t.Errorf("Wrong tenant for %s.  Expected %s, received %s (%v)", testcase.url, testcase.tenantID, resolution.TenantID, err)
+		}
+	}
+	if _, err := resolver.ResolveURL("https://other.example/t?p=CBF5374BC4874E7AE53961E6533DDC&m=C4B7E3310EFC2FA3"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Expected unknown tenant error, received %v", err)
+	}
+	if _, err := resolver.ResolveURL("https://other.example/t"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Expected unknown tenant error, received %v", err)
+	}
+	if _, err := resolver.ResolvePICCData("CBF5374BC4874E7AE53961E6533DDC5F", "0000000000000000"); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("Expected unknown tenant error, received %v", err)
+	}
+	if _, err := resolver.ResolvePICCData("CBF5374BC4874E7AE53961E6533DDC5Z", "C4B7E3310EFC2FA3"); !errors.Is(err, ErrBadHex) {
+		t.Errorf("Expected bad hex error, received %v", err)
+	}
+}
+
+func TestTenantResolverSingleCandidate(t *testing.T) {
+	// Leave out the zero-key AES tenant, whose key happens to give a valid PICCDataTag
+	resolver, _ := NewTenantResolver(testResolverTenants(0)[1:])
+
+	// Only one tenant decodes the PICCData, so it is given even though the MAC is wrong
+	resolution, err := resolver.ResolvePICCData("CBF5374BC4874E7AE53961E6533DDC5F", "0000000000000000")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resolution.TenantID != "acme" || resolution.Validated {
+		t.Errorf("Expected an unvalidated result for acme, received %s (%+v)", resolution.TenantID, resolution.Result)
+	}
+}
+
+func TestNewTenantResolverErrors(t *testing.T) {
+	tenants := testResolverTenants(0)
+	if _, err := NewTenantResolver(append(tenants, tenants[0])); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected invalid config error, received %v", err)
+	}
+	if _, err := NewTenantResolver([]Tenant{Tenant{ID: "none"}}); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Expected missing key error, received %v", err)
+	}
+	badKeyset := *testVerifyKeyset(AES)
+	badKeyset.Keys = []Key{Key{KeyData: []byte{1, 2, 3}}}
+	if _, err := NewTenantResolver([]Tenant{Tenant{ID: "bad", Keyset: &badKeyset}}); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+}
+
+func BenchmarkTenantResolver(b *testing.B) {
+	resolver, _ := NewTenantResolver(testResolverTenants(500))
+	for i := 0; i < b.N; i++ {
+		resolver.ResolvePICCData("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	}
+}
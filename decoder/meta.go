@@ -3,6 +3,8 @@ package decoder
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"io"
 
 	"github.com/johnnyb/gocrypto/lrp"
 )
@@ -11,10 +13,31 @@ import (
 // UID is an integer representation of the chips ID (convertible
 // to a byte string by calling UidBytes().
 // ReadCounter is the number of times the chip has been scanned.
+// HasUid and HasReadCounter tell whether the chip mirrored the UID and
+// read counter; when it did not, the field is -1.  The session keys depend
+// on which fields were mirrored.  If neither is set (as in Metas made before
+// these were added), a field counts as mirrored unless it is -1.
+// UIDFromPICCData is set when the keyset's tags use Random ID, so the UID
+// came from the encrypted PICCData only (the UID the reader saw during
+// anticollision is random, and will not match it).
 type Meta struct {
-	Uid         int64
-	ReadCounter int32
-	Keyset      *Keyset
+	Uid             int64
+	ReadCounter     int32
+	Keyset          *Keyset
+	HasUid          bool
+	HasReadCounter  bool
+	UIDFromPICCData bool
+}
+
+// NewMeta creates a Meta with both the UID and the read counter mirrored.
+func NewMeta(uid int64, readCounter int32, keyset *Keyset) Meta {
+	return Meta{
+		Uid:            uid,
+		ReadCounter:    readCounter,
+		Keyset:         keyset,
+		HasUid:         true,
+		HasReadCounter: true,
+	}
 }
 
 // UidBytes decodes the UID into a byte string.
@@ -29,6 +52,43 @@ func (meta *Meta) UidHex() string {
 	return hex.EncodeToString(meta.UidBytes())
 }
 
+// uidMirrored tells whether the chip mirrored the UID (see Meta).
+func (meta *Meta) uidMirrored() bool {
+	if meta.HasUid || meta.HasReadCounter {
+		return meta.HasUid
+	}
+	return meta.Uid >= 0
+}
+
+// readCounterMirrored tells whether the chip mirrored the read counter (see Meta).
+func (meta *Meta) readCounterMirrored() bool {
+	if meta.HasUid || meta.HasReadCounter {
+		return meta.HasReadCounter
+	}
+	return meta.ReadCounter >= 0
+}
+
+// mirroredUidBytes gives the UID bytes, or nil if the UID was not mirrored
+// (so that keys are never diversified with a missing UID).
+func (meta *Meta) mirroredUidBytes() []byte {
+	if !meta.uidMirrored() {
+		return nil
+	}
+	return meta.UidBytes()
+}
+
+// checkFileDataMirrors checks that both the UID and the read counter were
+// mirrored, which the chip requires for encrypting file data.
+func (meta *Meta) checkFileDataMirrors() error {
+	if !meta.uidMirrored() {
+		return fmt.Errorf("%w: encrypted file data needs the UID", ErrNoUID)
+	}
+	if !meta.readCounterMirrored() {
+		return fmt.Errorf("%w: encrypted file data needs the read counter", ErrNoReadCounter)
+	}
+	return nil
+}
+
 // ReadCounterBytes retrieves the ReadCounter as a byte array (the way it is stored on the chip)
 func (meta *Meta) ReadCounterBytes() []byte {
 	counterBytes := make([]byte, 4)
@@ -36,16 +96,24 @@ func (meta *Meta) ReadCounterBytes() []byte {
 	return counterBytes[0:3]
 }
 
+// DecryptFileData decrypts the SDMENCFileData using the keyset's FileReadKey.
+// It gives nil if the key can't be generated (e.g., if a Provider doesn't have the UID's key).
 func (meta *Meta) DecryptFileData(data []byte) []byte {
 	if meta.Keyset.FileReadKey == KEY_NONE {
 		return data
 	}
-	keyBytes := meta.Keyset.Keys[meta.Keyset.FileReadKey].GenerateKeyBytes(meta.UidBytes())
+	keyBytes, err := meta.Keyset.Keys[meta.Keyset.FileReadKey].generateKeyBytes(meta.mirroredUidBytes())
+	if err != nil {
+		// E.g., a Provider without the tag's key
+		return nil
+	}
 	switch meta.Keyset.Mode {
 		case LRP:
-			return DecryptLRP(keyBytes, 0, meta.ReadCounterBytes(), data)
+			sessKey := meta.GenerateLRPSessionMACKey(keyBytes)
+			return DecryptLRP(sessKey, 1, meta.LRPFileDataCounter(), data)
 		case AES:
-			return DecryptAES(keyBytes, data)
+			sessKey := meta.GenerateAESSessionENCKey(keyBytes)
+			return DecryptAESWithIV(sessKey, meta.GenerateAESFileDataIV(sessKey), data)
 		default:
 			panic("Unknown encryption mode")
 	}
@@ -60,7 +128,16 @@ func (meta *Meta) GenerateValidationCode(data []byte) []byte {
 		data = []byte{}
 	}
 
-	macKey := meta.Keyset.Keys[meta.Keyset.AuthenticationKey].GenerateKeyBytes(meta.UidBytes())
+	key := &meta.Keyset.Keys[meta.Keyset.AuthenticationKey]
+	if (key.Diversified || key.Provider != nil) && !meta.uidMirrored() {
+		// The key can't be diversified or looked up, so nothing can validate
+		return []byte{}
+	}
+	macKey, err := key.generateKeyBytes(meta.mirroredUidBytes())
+	if err != nil {
+		// E.g., a Provider without the tag's key
+		return []byte{}
+	}
 
 	switch meta.Keyset.Mode {
 	case LRP:
@@ -89,39 +166,12 @@ func (meta *Meta) generateAESMACValidationCode(macKey []byte, extradata []byte)
 
 // GenerateLRPSessionMACKey takes the MAC key and generates a session key
 // for MAC-ing using the LRP algorithm.
+// Given the file read key, this also generates the session master key for
+// decrypting SDMENCFileData (which uses updated key 1 instead of 0).
 func (meta *Meta) GenerateLRPSessionMACKey(macKey []byte) []byte {
-	uidBytes := meta.UidBytes()
-	counterBytes := meta.ReadCounterBytes()
 	// pg. 42 and https://github.com/icedevml/ntag424-ev2-crypto/blob/master/test_lrp_sdm.py
-	sv := make([]byte, 16)
 	// SV = 00h || 01h || 00h || 80h [ || UID] [ || SDMReadCtr] [ || ZeroPadding] || 1Eh || E1h
-	sv[0] = 0x00
-	sv[1] = 0x01
-	sv[2] = 0x00
-	sv[3] = 0x80
-	svIdx := 4
-	if meta.Uid > 0 {
-		sv[4] = uidBytes[0]
-		sv[5] = uidBytes[1]
-		sv[6] = uidBytes[2]
-		sv[7] = uidBytes[3]
-		sv[8] = uidBytes[4]
-		sv[9] = uidBytes[5]
-		sv[10] = uidBytes[6]
-		svIdx = 11
-	}
-	if meta.ReadCounter > 0 {
-		sv[svIdx] = counterBytes[0]
-		sv[svIdx+1] = counterBytes[1]
-		sv[svIdx+2] = counterBytes[1]
-		svIdx += 3
-	}
-	for svIdx != 14 {
-		sv[svIdx] = 0x00
-		svIdx++
-	}
-	sv[14] = 0x1e
-	sv[15] = 0xe1
+	sv := meta.sessionVector([]byte{0x00, 0x01, 0x00, 0x80}, []byte{0x1e, 0xe1})
 
 	newKey := LRPMAC(macKey, 0, sv)
 	return newKey
@@ -136,44 +186,61 @@ func LRPMAC(key []byte, keyNum int, msg []byte) []byte {
 
 // GenerateAESSessionMACKey generates a session MAC key for AES encryption.
 func (meta *Meta) GenerateAESSessionMACKey(originalKey []byte) []byte {
-	uidBytes := meta.UidBytes()
-	counterBytes := meta.ReadCounterBytes()
-
-	sv := make([]byte, 16)
-	sv[0] = 0x3c
-	sv[1] = 0xc3
-	sv[2] = 0x00
-	sv[3] = 0x01
-	sv[4] = 0x00
-	sv[5] = 0x80
-
-	svIdx := 6
-	if meta.Uid > 0 {
-		sv[6] = uidBytes[0]
-		sv[7] = uidBytes[1]
-		sv[8] = uidBytes[2]
-		sv[9] = uidBytes[3]
-		sv[10] = uidBytes[4]
-		sv[11] = uidBytes[5]
-		sv[12] = uidBytes[6]
-		svIdx = 13
-	}
-	if meta.ReadCounter > 0 {
-		sv[svIdx] = counterBytes[0]
-		sv[svIdx+1] = counterBytes[1]
-		sv[svIdx+2] = counterBytes[1]
-		svIdx += 3
-	}
-	for svIdx != 16 {
-		sv[svIdx] = 0x00
-		svIdx++
-	}
+	// SV2 = 3Ch || C3h || 00h || 01h || 00h || 80h [ || UID] [ || SDMReadCtr] [ || ZeroPadding]
+	sv := meta.sessionVector([]byte{0x3c, 0xc3, 0x00, 0x01, 0x00, 0x80}, nil)
 
 	newKey := AESMAC(originalKey, sv)
 
 	return newKey
 }
 
+// GenerateAESSessionENCKey generates the session key used for decrypting
+// SDMENCFileData with AES encryption.
+func (meta *Meta) GenerateAESSessionENCKey(fileReadKey []byte) []byte {
+	// SV1 = C3h || 3Ch || 00h || 01h || 00h || 80h [ || UID] [ || SDMReadCtr] [ || ZeroPadding]
+	sv := meta.sessionVector([]byte{0xc3, 0x3c, 0x00, 0x01, 0x00, 0x80}, nil)
+
+	return AESMAC(fileReadKey, sv)
+}
+
+// GenerateAESFileDataIV generates the IV used for decrypting SDMENCFileData
+// with AES encryption from the session ENC key.
+func (meta *Meta) GenerateAESFileDataIV(sessionKey []byte) []byte {
+	// IV = E(KSesSDMFileReadENC; SDMReadCtr || 00h..00h)
+	ivInput := make([]byte, 16)
+	copy(ivInput, meta.ReadCounterBytes())
+
+	return EncryptAESBlock(sessionKey, ivInput)
+}
+
+// LRPFileDataCounter generates the LRP counter used for decrypting
+// SDMENCFileData (SDMReadCtr || 000000h).
+func (meta *Meta) LRPFileDataCounter() []byte {
+	counter := make([]byte, 6)
+	copy(counter, meta.ReadCounterBytes())
+	return counter
+}
+
+// sessionVector builds the 16-byte session vector used for deriving
+// session keys.  The UID and read counter are included if they were mirrored
+// (even if the read counter is zero).
+func (meta *Meta) sessionVector(prefix []byte, suffix []byte) []byte {
+	sv := make([]byte, 0, 16)
+	sv = append(sv, prefix...)
+	if meta.uidMirrored() {
+		sv = append(sv, meta.UidBytes()...)
+	}
+	if meta.readCounterMirrored() {
+		sv = append(sv, meta.ReadCounterBytes()...)
+	}
+	for len(sv) < 16-len(suffix) {
+		sv = append(sv, 0x00)
+	}
+	sv = append(sv, suffix...)
+
+	return sv
+}
+
 // DecryptMetaLRPString decrypts metadata from the given string, assuming the string is encoded in hexadecimal.
 func DecryptMetaLRPString(key []byte, data string) Meta {
 	val, _ := hex.DecodeString(data)
@@ -227,6 +294,7 @@ func Deserialize(data []byte) Meta {
 		uidBytes := make([]byte, 8)
 		copy(uidBytes, data[curidx:(curidx+7)])
 		meta.Uid = int64(binary.LittleEndian.Uint64(uidBytes))
+		meta.HasUid = true
 		curidx += 7
 	}
 	if (tag & 0b01000000) == 0 {
@@ -235,7 +303,168 @@ func Deserialize(data []byte) Meta {
 		counterBytes := make([]byte, 4)
 		copy(counterBytes, data[curidx:(curidx+3)])
 		meta.ReadCounter = int32(binary.LittleEndian.Uint32(counterBytes))
+		meta.HasReadCounter = true
 	}
 
 	return meta
 }
+
+// ParsePICCData is like Deserialize, but checks the PICCDataTag and the data
+// length, returning an error instead of panicking.  Data after the mirrored
+// fields (i.e., padding) is ignored.
+func ParsePICCData(data []byte) (Meta, error) {
+	if len(data) == 0 {
+		return Meta{}, fmt.Errorf("%w: empty PICCData", ErrWrongLength)
+	}
+	tag := data[0]
+	if (tag & 0b00110000) != 0 {
+		return Meta{}, fmt.Errorf("%w: RFU bits set in %02x", ErrInvalidPICCDataTag, tag)
+	}
+	if (tag & 0b11000000) == 0 {
+		return Meta{}, fmt.Errorf("%w: no mirrored fields in %02x", ErrInvalidPICCDataTag, tag)
+	}
+	expectedLength := 1
+	if (tag & 0b10000000) != 0 {
+		if (tag & 0b00001111) != 7 {
+			return Meta{}, fmt.Errorf("%w: unsupported UID length in %02x", ErrInvalidPICCDataTag, tag)
+		}
+		expectedLength += 7
+	} else if (tag & 0b00001111) != 0 {
+		return Meta{}, fmt.Errorf("%w: UID length without a UID in %02x", ErrInvalidPICCDataTag, tag)
+	}
+	if (tag & 0b01000000) != 0 {
+		expectedLength += 3
+	}
+	if len(data) < expectedLength {
+		return Meta{}, fmt.Errorf("%w: PICCData is %d bytes, expected at least %d", ErrWrongLength, len(data), expectedLength)
+	}
+
+	return Deserialize(data), nil
+}
+
+// decryptMetaAES is the error-returning version of DecryptMetaAES.
+func decryptMetaAES(key []byte, data []byte) (Meta, error) {
+	if len(data) != 16 {
+		return Meta{}, fmt.Errorf("%w: AES PICCData is %d bytes, expected 16", ErrWrongLength, len(data))
+	}
+	if err := checkAESInput(key, data); err != nil {
+		return Meta{}, err
+	}
+	return ParsePICCData(DecryptAES(key, data))
+}
+
+// decryptMetaLRP is the error-returning version of DecryptMetaLRP.
+func decryptMetaLRP(key []byte, data []byte) (Meta, error) {
+	if len(data) != 24 {
+		return Meta{}, fmt.Errorf("%w: LRP PICCData is %d bytes, expected 24", ErrWrongLength, len(data))
+	}
+	if err := checkLRPInput(key, data[0:8], data[8:24]); err != nil {
+		return Meta{}, err
+	}
+	return ParsePICCData(DecryptLRP(key, 0, data[0:8], data[8:24]))
+}
+
+// decodeUnencryptedMeta is the error-returning version of DecodeUnencryptedBytes.
+func decodeUnencryptedMeta(data []byte) (Meta, error) {
+	if len(data) != 10 {
+		return Meta{}, fmt.Errorf("%w: unencrypted PICCData is %d bytes, expected 10", ErrWrongLength, len(data))
+	}
+	return DecodeUnencryptedBytes(data), nil
+}
+
+// validationCode is the error-returning version of GenerateValidationCode.
+func (meta *Meta) validationCode(data []byte) ([]byte, error) {
+	if meta.Keyset == nil {
+		return nil, fmt.Errorf("%w: meta has no keyset", ErrMissingKey)
+	}
+	if data == nil {
+		data = []byte{}
+	}
+
+	macKey, err := meta.Keyset.keyBytes(meta.Keyset.AuthenticationKey, meta.mirroredUidBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	switch meta.Keyset.Mode {
+	case LRP:
+		return meta.generateLRPMACValidationCode(macKey, data), nil
+	case AES:
+		return meta.generateAESMACValidationCode(macKey, data), nil
+	default:
+		return nil, ErrUnknownMode
+	}
+}
+
+// Serialize encodes the meta as PICCData (the inverse of Deserialize).
+// Fields which were not mirrored are not included.  There is no padding.
+func Serialize(meta Meta) []byte {
+	data := []byte{0}
+	if meta.uidMirrored() {
+		data[0] |= 0b10000111
+		data = append(data, meta.UidBytes()...)
+	}
+	if meta.readCounterMirrored() {
+		data[0] |= 0b01000000
+		data = append(data, meta.ReadCounterBytes()...)
+	}
+	return data
+}
+
+// EncryptMetaAES encrypts the meta as a chip would (the inverse of DecryptMetaAES),
+// using random for the padding.
+func EncryptMetaAES(key []byte, meta Meta, random io.Reader) ([]byte, error) {
+	data := make([]byte, 16)
+	serialized := Serialize(meta)
+	copy(data, serialized)
+	if _, err := io.ReadFull(random, data[len(serialized):]); err != nil {
+		return nil, err
+	}
+	return EncryptAES(key, data), nil
+}
+
+// EncryptMetaLRP encrypts the meta as a chip would (the inverse of DecryptMetaLRP),
+// using random for PICCRand and the padding.
+func EncryptMetaLRP(key []byte, meta Meta, random io.Reader) ([]byte, error) {
+	data := make([]byte, 24)
+	serialized := Serialize(meta)
+	copy(data[8:], serialized)
+	if _, err := io.ReadFull(random, data[0:8]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(random, data[(8+len(serialized)):]); err != nil {
+		return nil, err
+	}
+	result := append([]byte{}, data[0:8]...)
+	return append(result, EncryptLRP(key, 0, data[0:8], data[8:24])...), nil
+}
+
+// EncodeUnencryptedBytes encodes the UID and read counter as a chip
+// mirrors them in plain (the inverse of DecodeUnencryptedBytes).
+func EncodeUnencryptedBytes(meta Meta) []byte {
+	data := append([]byte{}, meta.UidBytes()...)
+	counterBytes := meta.ReadCounterBytes()
+	return append(data, counterBytes[2], counterBytes[1], counterBytes[0])
+}
+
+// EncryptFileData encrypts file data as a chip would (the inverse of DecryptFileData).
+// The data must be a multiple of 16 bytes.  It gives nil if the key can't be generated.
+func (meta *Meta) EncryptFileData(data []byte) []byte {
+	if meta.Keyset.FileReadKey == KEY_NONE {
+		return data
+	}
+	keyBytes, err := meta.Keyset.Keys[meta.Keyset.FileReadKey].generateKeyBytes(meta.mirroredUidBytes())
+	if err != nil {
+		return nil
+	}
+	switch meta.Keyset.Mode {
+	case LRP:
+		sessKey := meta.GenerateLRPSessionMACKey(keyBytes)
+		return EncryptLRP(sessKey, 1, meta.LRPFileDataCounter(), data)
+	case AES:
+		sessKey := meta.GenerateAESSessionENCKey(keyBytes)
+		return EncryptAESWithIV(sessKey, meta.GenerateAESFileDataIV(sessKey), data)
+	default:
+		panic("Unknown encryption mode")
+	}
+}
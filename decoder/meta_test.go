@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	mathrand "math/rand"
 	"strings"
 	"testing"
 
@@ -160,3 +162,292 @@ func TestMetaLRP(t *testing.T) {
 		t.Errorf("Bad LRP MAC: %s", hex.EncodeToString(code))
 	}
 }
+
+func TestDecryptFileDataAES(t *testing.T) {
+	// SUN message with SDMENCFileData, from https://github.com/icedevml/sdm-backend
+	zeroKeyBinary, _ := hex.DecodeString(zeroKey)
+	keyset := Keyset{
+		Mode: AES,
+		Keys: []Key{
+			Key{
+				KeyData: zeroKeyBinary,
+			},
+		},
+		MetaReadKey:       0,
+		FileReadKey:       0,
+		AuthenticationKey: 0,
+	}
+	meta := keyset.DecodeEncryptedMetaString("FD91EC264309878BE6345CBE53BADF40")
+	if meta.UidHex() != "04958caa5c5e80" || meta.ReadCounter != 8 {
+		t.Fatalf("Wrong meta: %s / %d", meta.UidHex(), meta.ReadCounter)
+	}
+
+	encrypted, _ := hex.DecodeString("CEE9A53E3E463EF1F459635736738962")
+	fileData := meta.DecryptFileData(encrypted)
+	if string(fileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad file data: %s", hex.EncodeToString(fileData))
+	}
+	fileData, err := keyset.DecryptFileData(meta, encrypted)
+	if err != nil || string(fileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad file data: %s (%v)", hex.EncodeToString(fileData), err)
+	}
+
+	// The MAC covers the mirrored file data
+	mac, _ := hex.DecodeString("ECC1E7F6C6C73BF6")
+	code := meta.GenerateValidationCode([]byte("CEE9A53E3E463EF1F459635736738962&cmac="))
+	if !bytes.Equal(code, mac) {
+		t.Errorf("Bad MAC: %s", hex.EncodeToString(code))
+	}
+}
+
+func TestDecryptFileDataLRP(t *testing.T) {
+	// SUN message with SDMENCFileData in LRP mode, from https://github.com/icedevml/sdm-backend
+	zeroKeyBinary, _ := hex.DecodeString(zeroKey)
+	keyset := Keyset{
+		Mode: LRP,
+		Keys: []Key{
+			Key{
+				KeyData: zeroKeyBinary,
+			},
+		},
+		MetaReadKey:       0,
+		FileReadKey:       0,
+		AuthenticationKey: 0,
+	}
+	meta := keyset.DecodeEncryptedMetaString("07D9CA2545881D4BFDD920BE1603268C0714420DD893A497")
+	if meta.UidHex() != "049b112a2f7080" || meta.ReadCounter != 4 {
+		t.Fatalf("Wrong meta: %s / %d", meta.UidHex(), meta.ReadCounter)
+	}
+
+	encrypted, _ := hex.DecodeString("D6E921C47DB4C17C56F979F81559BB83")
+	fileData := meta.DecryptFileData(encrypted)
+	if string(fileData) != "NTXXb7dz3PsYYBlU" {
+		t.Errorf("Bad file data: %s", hex.EncodeToString(fileData))
+	}
+	fileData, err := keyset.DecryptFileData(meta, encrypted)
+	if err != nil || string(fileData) != "NTXXb7dz3PsYYBlU" {
+		t.Errorf("Bad file data: %s (%v)", hex.EncodeToString(fileData), err)
+	}
+
+	mac, _ := hex.DecodeString("F9481AC7D855BDB6")
+	code := meta.GenerateValidationCode([]byte("D6E921C47DB4C17C56F979F81559BB83&cmac="))
+	if !bytes.Equal(code, mac) {
+		t.Errorf("Bad MAC: %s", hex.EncodeToString(code))
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	metaBytes, _ := hex.DecodeString("C704DE5F1EACC0403D0000")
+	if result := hex.EncodeToString(Serialize(Deserialize(metaBytes))); result != "c704de5f1eacc0403d0000" {
+		t.Errorf("Bad serialization: %s", result)
+	}
+	metaBytes, _ = hex.DecodeString("870432272aaa6180")
+	if result := hex.EncodeToString(Serialize(Deserialize(metaBytes))); result != "870432272aaa6180" {
+		t.Errorf("Bad serialization: %s", result)
+	}
+}
+
+func TestEncryptMetaRoundTrip(t *testing.T) {
+	random := mathrand.New(mathrand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 16)
+		random.Read(key)
+		meta := NewMeta(random.Int63n(1<<56), random.Int31n(1<<24), nil)
+		switch random.Intn(3) {
+		case 0:
+			meta.Uid = -1
+			meta.HasUid = false
+		case 1:
+			meta.ReadCounter = -1
+			meta.HasReadCounter = false
+		}
+
+		if result := Deserialize(Serialize(meta)); result != meta {
+			t.Errorf("Serialization round trip failed: %+v != %+v", result, meta)
+		}
+
+		encrypted, err := EncryptMetaAES(key, meta, random)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result := DecryptMetaAES(key, encrypted); result != meta {
+			t.Errorf("AES round trip failed: %+v != %+v", result, meta)
+		}
+
+		encrypted, err = EncryptMetaLRP(key, meta, random)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result := DecryptMetaLRP(key, encrypted); result != meta {
+			t.Errorf("LRP round trip failed: %+v != %+v", result, meta)
+		}
+
+		if meta.HasUid && meta.HasReadCounter {
+			if result := DecodeUnencryptedBytes(EncodeUnencryptedBytes(meta)); result != meta {
+				t.Errorf("Unencrypted round trip failed: %+v != %+v", result, meta)
+			}
+		}
+	}
+}
+
+func TestEncryptFileDataRoundTrip(t *testing.T) {
+	random := mathrand.New(mathrand.NewSource(1))
+	for _, mode := range []EncryptionMode{AES, LRP} {
+		for i := 0; i < 50; i++ {
+			key := make([]byte, 16)
+			random.Read(key)
+			data := make([]byte, 16*(1+random.Intn(3)))
+			random.Read(data)
+			meta := Meta{
+				Uid:         random.Int63n(1 << 56),
+				ReadCounter: random.Int31n(1 << 24),
+				Keyset: &Keyset{
+					Mode:        mode,
+					Keys:        []Key{Key{KeyData: key}},
+					FileReadKey: 0,
+				},
+			}
+			if result := meta.DecryptFileData(meta.EncryptFileData(data)); !bytes.Equal(result, data) {
+				t.Errorf("File data round trip failed: %s != %s", hex.EncodeToString(result), hex.EncodeToString(data))
+			}
+		}
+	}
+
+	// Encrypting the published plaintext gives the published ciphertext
+	keyset := Keyset{
+		Mode: LRP,
+		Keys: []Key{Key{KeyData: make([]byte, 16)}},
+	}
+	meta := keyset.DecodeEncryptedMetaString("07D9CA2545881D4BFDD920BE1603268C0714420DD893A497")
+	if result := hex.EncodeToString(meta.EncryptFileData([]byte("NTXXb7dz3PsYYBlU"))); result != "d6e921c47db4c17c56f979f81559bb83" {
+		t.Errorf("Bad file data encryption: %s", result)
+	}
+}
+
+func TestSessionVectorMirroring(t *testing.T) {
+	var uid int64 = 0x805e5caa8c9504 // 04958caa5c5e80
+	uidOnly := NewMeta(uid, -1, nil)
+	uidOnly.HasReadCounter = false
+	counterOnly := NewMeta(-1, 8, nil)
+	counterOnly.HasUid = false
+	testcases := []struct {
+		name string
+		meta Meta
+		aes  string
+		lrp  string
+	}{
+		{"both", NewMeta(uid, 8, nil), "3cc30001008004958caa5c5e80080000", "0001008004958caa5c5e800800001ee1"},
+		{"counter zero", NewMeta(uid, 0, nil), "3cc30001008004958caa5c5e80000000", "0001008004958caa5c5e800000001ee1"},
+		{"UID only", uidOnly, "3cc30001008004958caa5c5e80000000", "0001008004958caa5c5e800000001ee1"},
+		{"counter only", counterOnly, "3cc30001008008000000000000000000", "00010080080000000000000000001ee1"},
+		// Metas without the flags go by the -1 sentinel, as before they were added
+		{"literal", Meta{Uid: uid, ReadCounter: 8}, "3cc30001008004958caa5c5e80080000", "0001008004958caa5c5e800800001ee1"},
+		{"literal UID only", Meta{Uid: uid, ReadCounter: -1}, "3cc30001008004958caa5c5e80000000", "0001008004958caa5c5e800000001ee1"},
+		{"literal counter only", Meta{Uid: -1, ReadCounter: 8}, "3cc30001008008000000000000000000", "00010080080000000000000000001ee1"},
+	}
+	for _, testcase := range testcases {
+		aes := hex.EncodeToString(testcase.meta.sessionVector([]byte{0x3c, 0xc3, 0x00, 0x01, 0x00, 0x80}, nil))
+		if aes != testcase.aes {
+			t.Errorf("Wrong AES session vector for %s. Expected %s, received %s", testcase.name, testcase.aes, aes)
+		}
+		lrp := hex.EncodeToString(testcase.meta.sessionVector([]byte{0x00, 0x01, 0x00, 0x80}, []byte{0x1e, 0xe1}))
+		if lrp != testcase.lrp {
+			t.Errorf("Wrong LRP session vector for %s. Expected %s, received %s", testcase.name, testcase.lrp, lrp)
+		}
+	}
+}
+
+func TestMirrorOptions(t *testing.T) {
+	random := mathrand.New(mathrand.NewSource(1))
+	testcases := []struct {
+		name       string
+		options    byte
+		hasUid     bool
+		hasCounter bool
+	}{
+		{"UID only", SDM_OPTION_UID, true, false},
+		{"counter only", SDM_OPTION_READ_CTR, false, true},
+		{"counter zero", SDM_OPTION_UID | SDM_OPTION_READ_CTR, true, true},
+	}
+	for _, mode := range []EncryptionMode{AES, LRP} {
+		keyset := &Keyset{
+			Mode:              mode,
+			Keys:              []Key{Key{KeyData: make([]byte, 16)}, Key{KeyData: bytes.Repeat([]byte{1}, 16)}},
+			MetaReadKey:       0,
+			FileReadKey:       1,
+			AuthenticationKey: 1,
+		}
+		// Encrypted file data needs both the UID and the read counter, so it is only in the last testcase
+		layout := NewSDMLayout()
+		file := testVirtualTagFile("https://x.example/t?p="+strings.Repeat("0", keyset.PICCDataLength())+"&e="+strings.Repeat("0", 32)+"&m="+strings.Repeat("0", 16), map[string]*int{
+			"p=": &layout.PICCDataOffset,
+			"e=": &layout.SDMMACInputOffset,
+			"m=": &layout.SDMMACOffset,
+		})
+
+		for _, testcase := range testcases {
+			if testcase.hasUid && testcase.hasCounter {
+				layout.SDMENCOffset = layout.SDMMACInputOffset
+				layout.SDMENCLength = 32
+			}
+			tag := VirtualTag{
+				Uid: 0x805e5caa8c9504,
+				// The first tap has a read counter of zero
+				ReadCounter: -1,
+				Keyset:      keyset,
+				Layout:      layout,
+				File:        file,
+				FileData:    []byte("mirrored options"),
+				Rand:        random,
+				SDMOptions:  testcase.options,
+			}
+			tapUrl, err := tag.Tap()
+			if err != nil {
+				t.Fatalf("Unexpected error for %s: %v", testcase.name, err)
+			}
+			result, err := keyset.VerifyLayoutURL(layout, tapUrl)
+			if err != nil {
+				t.Fatalf("Unexpected error for %s: %v", testcase.name, err)
+			}
+			meta := result.Meta
+			if !result.Validated {
+				t.Errorf("Not validated for %s", testcase.name)
+			}
+			if layout.SDMENCOffset != OFFSET_NONE && string(result.FileData) != "mirrored options" {
+				t.Errorf("Wrong file data for %s: %q", testcase.name, result.FileData)
+			}
+			if meta.HasUid != testcase.hasUid || meta.HasReadCounter != testcase.hasCounter {
+				t.Errorf("Wrong fields for %s: %+v", testcase.name, meta)
+			}
+			if testcase.hasCounter && meta.ReadCounter != 0 {
+				t.Errorf("Wrong read counter for %s. Expected 0, received %d", testcase.name, meta.ReadCounter)
+			}
+			if !testcase.hasUid && meta.Uid != -1 {
+				t.Errorf("Wrong UID for %s. Expected -1, received %d", testcase.name, meta.Uid)
+			}
+		}
+
+		// The chip can't encrypt file data without both
+		layout.SDMENCOffset = layout.SDMMACInputOffset
+		layout.SDMENCLength = 32
+		tag := VirtualTag{Uid: 0x805e5caa8c9504, Keyset: keyset, Layout: layout, File: file, Rand: random, SDMOptions: SDM_OPTION_UID}
+		if _, err := tag.Tap(); !errors.Is(err, ErrNoReadCounter) {
+			t.Errorf("Expected no read counter error, received %v", err)
+		}
+	}
+}
+
+func TestDiversifiedKeyWithoutUID(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	meta := NewMeta(-1, 3, keyset)
+	meta.HasUid = false
+	if _, err := meta.validationCode([]byte{}); !errors.Is(err, ErrNoUID) {
+		t.Errorf("Expected no UID error, received %v", err)
+	}
+	if _, err := keyset.DecryptFileData(meta, make([]byte, 16)); !errors.Is(err, ErrNoUID) {
+		t.Errorf("Expected no UID error, received %v", err)
+	}
+	if code := meta.GenerateValidationCode([]byte{}); len(code) != 0 {
+		t.Errorf("Expected no validation code, received %s", hex.EncodeToString(code))
+	}
+}
@@ -0,0 +1,232 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Type name formats of NDEF records.
+const (
+	TNF_EMPTY        = 0x00
+	TNF_WELL_KNOWN   = 0x01
+	TNF_MEDIA        = 0x02
+	TNF_ABSOLUTE_URI = 0x03
+	TNF_EXTERNAL     = 0x04
+	TNF_UNKNOWN      = 0x05
+	TNF_UNCHANGED    = 0x06
+)
+
+// Flags in the NDEF record header.
+const (
+	ndefMessageBegin = 0b10000000
+	ndefMessageEnd   = 0b01000000
+	ndefChunk        = 0b00100000
+	ndefShortRecord  = 0b00010000
+	ndefIDLength     = 0b00001000
+	ndefTNFMask      = 0b00000111
+)
+
+// NDEFRecord is a single record of an NDEF message.
+// PayloadOffset is the offset of the payload in the data the record was parsed from.
+type NDEFRecord struct {
+	TNF           byte
+	Type          []byte
+	ID            []byte
+	Payload       []byte
+	PayloadOffset int
+}
+
+// ParseNDEFFile parses the contents of an NDEF file (an NDEF message
+// preceded by its 2-byte length, NLEN), such as the one on an NTAG 424 DNA.
+// Payload offsets are relative to the start of the file.
+func ParseNDEFFile(file []byte) ([]NDEFRecord, error) {
+	if len(file) < 2 {
+		return nil, fmt.Errorf("%w: file is %d bytes", ErrInvalidNDEF, len(file))
+	}
+	length := int(binary.BigEndian.Uint16(file))
+	if length > len(file)-2 {
+		return nil, fmt.Errorf("%w: NLEN is %d, but only %d bytes follow", ErrInvalidNDEF, length, len(file)-2)
+	}
+	return parseNDEFMessage(file[0:(2+length)], 2)
+}
+
+// ParseNDEFMessage parses an NDEF message (a series of records).
+func ParseNDEFMessage(data []byte) ([]NDEFRecord, error) {
+	return parseNDEFMessage(data, 0)
+}
+
+func parseNDEFMessage(data []byte, offset int) ([]NDEFRecord, error) {
+	records := []NDEFRecord{}
+	for offset < len(data) {
+		header := data[offset]
+		if len(records) == 0 && (header&ndefMessageBegin) == 0 {
+			return nil, fmt.Errorf("%w: first record does not have the MB flag", ErrInvalidNDEF)
+		}
+		if (header & ndefChunk) != 0 {
+			return nil, fmt.Errorf("%w: chunked records are not supported", ErrInvalidNDEF)
+		}
+		offset++
+
+		// TYPE_LENGTH, PAYLOAD_LENGTH (1 or 4 bytes), and ID_LENGTH (if IL is set)
+		lengthsSize := 1 + 4
+		if (header & ndefShortRecord) != 0 {
+			lengthsSize = 1 + 1
+		}
+		if (header & ndefIDLength) != 0 {
+			lengthsSize++
+		}
+		if offset > len(data)-lengthsSize {
+			return nil, fmt.Errorf("%w: record header is truncated", ErrInvalidNDEF)
+		}
+		typeLength := int(data[offset])
+		offset++
+		var payloadLength int
+		if (header & ndefShortRecord) != 0 {
+			payloadLength = int(data[offset])
+			offset++
+		} else {
+			payloadLength32 := binary.BigEndian.Uint32(data[offset:])
+			if payloadLength32 > uint32(len(data)) {
+				return nil, fmt.Errorf("%w: payload length %d is longer than the message", ErrInvalidNDEF, payloadLength32)
+			}
+			payloadLength = int(payloadLength32)
+			offset += 4
+		}
+		idLength := 0
+		if (header & ndefIDLength) != 0 {
+			idLength = int(data[offset])
+			offset++
+		}
+		if offset > len(data)-typeLength-idLength-payloadLength {
+			return nil, fmt.Errorf("%w: record is truncated", ErrInvalidNDEF)
+		}
+
+		record := NDEFRecord{
+			TNF:  header & ndefTNFMask,
+			Type: data[offset:(offset + typeLength)],
+		}
+		offset += typeLength
+		record.ID = data[offset:(offset + idLength)]
+		offset += idLength
+		record.Payload = data[offset:(offset + payloadLength)]
+		record.PayloadOffset = offset
+		offset += payloadLength
+		records = append(records, record)
+
+		if (header & ndefMessageEnd) != 0 {
+			break
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: no records", ErrInvalidNDEF)
+	}
+
+	return records, nil
+}
+
+// IsURI tells whether the record is a URI record (either a well-known
+// URI record or an absolute URI).
+func (record *NDEFRecord) IsURI() bool {
+	if record.TNF == TNF_ABSOLUTE_URI {
+		return true
+	}
+	return record.TNF == TNF_WELL_KNOWN && string(record.Type) == "U"
+}
+
+// URI gives the URI of a URI record, expanding the URI prefix abbreviation.
+func (record *NDEFRecord) URI() (string, error) {
+	if record.TNF == TNF_ABSOLUTE_URI {
+		return string(record.Type), nil
+	}
+	if !record.IsURI() {
+		return "", fmt.Errorf("%w: not a URI record", ErrInvalidNDEF)
+	}
+	if len(record.Payload) == 0 {
+		return "", fmt.Errorf("%w: empty URI record", ErrInvalidNDEF)
+	}
+	code := int(record.Payload[0])
+	if code >= len(URIPrefixes) {
+		return "", fmt.Errorf("%w: unknown URI prefix code %d", ErrInvalidNDEF, code)
+	}
+	return URIPrefixes[code] + string(record.Payload[1:]), nil
+}
+
+// Text gives the text and language code of a well-known Text record.
+func (record *NDEFRecord) Text() (text string, language string, err error) {
+	if record.TNF != TNF_WELL_KNOWN || string(record.Type) != "T" {
+		return "", "", fmt.Errorf("%w: not a Text record", ErrInvalidNDEF)
+	}
+	if len(record.Payload) == 0 {
+		return "", "", fmt.Errorf("%w: empty Text record", ErrInvalidNDEF)
+	}
+	status := record.Payload[0]
+	languageLength := int(status & 0b00111111)
+	if languageLength > len(record.Payload)-1 {
+		return "", "", fmt.Errorf("%w: Text record language is truncated", ErrInvalidNDEF)
+	}
+	language = string(record.Payload[1:(1 + languageLength)])
+	encoded := record.Payload[(1 + languageLength):]
+
+	if (status & 0b10000000) == 0 {
+		return string(encoded), language, nil
+	}
+
+	// UTF-16, big endian unless there is a byte order mark
+	if len(encoded)%2 != 0 {
+		return "", "", fmt.Errorf("%w: odd-length UTF-16 text", ErrInvalidNDEF)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if len(encoded) >= 2 && encoded[0] == 0xff && encoded[1] == 0xfe {
+		order = binary.LittleEndian
+		encoded = encoded[2:]
+	} else if len(encoded) >= 2 && encoded[0] == 0xfe && encoded[1] == 0xff {
+		encoded = encoded[2:]
+	}
+	units := make([]uint16, len(encoded)/2)
+	for idx := range units {
+		units[idx] = order.Uint16(encoded[(2 * idx):])
+	}
+	return string(utf16.Decode(units)), language, nil
+}
+
+// FindSUNURI gives the URI of the first URI record, which is where chips mirror SUN messages.
+func FindSUNURI(records []NDEFRecord) (string, error) {
+	for idx := range records {
+		if records[idx].IsURI() {
+			return records[idx].URI()
+		}
+	}
+	return "", fmt.Errorf("%w: no URI record", ErrInvalidNDEF)
+}
+
+// NDEFFileFromMessage adds the NLEN field to an NDEF message, giving the
+// contents of the NDEF file (which is what SDM offsets are relative to).
+func NDEFFileFromMessage(message []byte) []byte {
+	file := make([]byte, 2, 2+len(message))
+	binary.BigEndian.PutUint16(file, uint16(len(message)))
+	return append(file, message...)
+}
+
+// VerifyNDEF verifies the SUN message in a raw NDEF message (as read by a phone),
+// using the template to find the parts of the SUN message in the first URI record.
+func (keyset *Keyset) VerifyNDEF(template *URLTemplate, message []byte) (Result, error) {
+	records, err := ParseNDEFMessage(message)
+	if err != nil {
+		return Result{}, err
+	}
+	uri, err := FindSUNURI(records)
+	if err != nil {
+		return Result{}, err
+	}
+	return keyset.VerifyURL(template, uri)
+}
+
+// VerifyNDEFLayout verifies the SUN message in a raw NDEF message (as read by a
+// phone) using the chip's offsets.
+func (keyset *Keyset) VerifyNDEFLayout(layout SDMLayout, message []byte) (Result, error) {
+	if _, err := ParseNDEFMessage(message); err != nil {
+		return Result{}, err
+	}
+	return keyset.VerifyLayout(layout, NDEFFileFromMessage(message))
+}
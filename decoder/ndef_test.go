@@ -0,0 +1,162 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestParseNDEFMessage(t *testing.T) {
+	// A Text record ("en", "hi"), a UTF-16 Text record, and a URI record
+	// (in the long-record form)
+	message, _ := hex.DecodeString("9101055402656e6869" + "1101075482656efeff0068" + "410100000008550473646d2e78797a")
+	records, err := ParseNDEFMessage(message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Wrong number of records: %d", len(records))
+	}
+	text, language, err := records[0].Text()
+	if err != nil || text != "hi" || language != "en" {
+		t.Errorf("Bad text record: %s / %s / %v", text, language, err)
+	}
+	text, language, err = records[1].Text()
+	if err != nil || text != "h" || language != "en" {
+		t.Errorf("Bad UTF-16 text record: %s / %s / %v", text, language, err)
+	}
+	uri, err := records[2].URI()
+	if err != nil || uri != "https://sdm.xyz" {
+		t.Errorf("Bad URI record: %s / %v", uri, err)
+	}
+	if records[2].PayloadOffset != 27 {
+		t.Errorf("Bad payload offset: %d", records[2].PayloadOffset)
+	}
+	uri, err = FindSUNURI(records)
+	if err != nil || uri != "https://sdm.xyz" {
+		t.Errorf("Bad SUN URI: %s / %v", uri, err)
+	}
+	if _, err := records[0].URI(); !errors.Is(err, ErrInvalidNDEF) {
+		t.Errorf("Expected invalid NDEF, received %v", err)
+	}
+
+	// Absolute URI record with an ID
+	message, _ = hex.DecodeString("db0f0001" + hex.EncodeToString([]byte("https://sdm.xyz")) + "69")
+	records, err = ParseNDEFMessage(message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	uri, _ = FindSUNURI(records)
+	if uri != "https://sdm.xyz" || string(records[0].ID) != "i" {
+		t.Errorf("Bad absolute URI record: %s / %s", uri, records[0].ID)
+	}
+
+	// Records with the shortest headers: the empty record, an empty record
+	// ending a message, and an empty Text record
+	shortcases := []struct {
+		message string
+		records int
+		payload int
+	}{
+		{"d00000", 1, 3},
+		{"9101055402656e6869" + "500000", 2, 12},
+		{"d1010054", 1, 4},
+	}
+	for _, shortcase := range shortcases {
+		message, _ = hex.DecodeString(shortcase.message)
+		records, err = ParseNDEFMessage(message)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", shortcase.message, err)
+			continue
+		}
+		last := records[len(records)-1]
+		if len(records) != shortcase.records || len(last.Payload) != 0 || last.PayloadOffset != shortcase.payload {
+			t.Errorf("Bad records for %s: %+v", shortcase.message, records)
+		}
+	}
+
+	records, err = ParseNDEFFile(NDEFFileFromURL("https://sdm.xyz/abc"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	uri, _ = FindSUNURI(records)
+	if uri != "https://sdm.xyz/abc" || records[0].PayloadOffset != 6 {
+		t.Errorf("Bad file URI: %s / %d", uri, records[0].PayloadOffset)
+	}
+}
+
+func TestParseNDEFMessageErrors(t *testing.T) {
+	testcases := []string{
+		"",
+		"d1",
+		"d10109550473646d2e7879",
+		"110109550473646d2e78797a",
+		"b10109550473646d2e78797a",
+		"c10100000000ff55",
+		"d1010055",
+		"d000",
+		"d90100",
+		"c1010000",
+	}
+	for _, testcase := range testcases {
+		message, _ := hex.DecodeString(testcase)
+		records, err := ParseNDEFMessage(message)
+		if err == nil {
+			_, err = records[0].URI()
+		}
+		if !errors.Is(err, ErrInvalidNDEF) {
+			t.Errorf("Expected invalid NDEF for %s, received %v", testcase, err)
+		}
+	}
+}
+
+func TestVerifyNDEF(t *testing.T) {
+	url := "https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6"
+	message := NDEFFileFromURL(url)[2:]
+	keyset := Keyset{
+		Mode: AES,
+		Keys: []Key{
+			Key{
+				KeyData: make([]byte, 16),
+			},
+		},
+	}
+
+	template, _ := ParseURLTemplate("https://sdm.example.com/tag?picc_data={picc}&enc={macinput}{enc}&cmac={cmac}")
+	result, err := keyset.VerifyNDEF(template, message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated || string(result.FileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad result: %t / %s", result.Validated, result.FileData)
+	}
+
+	layout := NewSDMLayout()
+	layout.PICCDataOffset = 0x25
+	layout.SDMENCOffset = 0x4a
+	layout.SDMENCLength = 32
+	layout.SDMMACInputOffset = 0x4a
+	layout.SDMMACOffset = 0x70
+	result, err = keyset.VerifyNDEFLayout(layout, message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated || string(result.FileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad result: %t / %s", result.Validated, result.FileData)
+	}
+}
+
+func FuzzParseNDEFMessage(f *testing.F) {
+	f.Add([]byte{0xd1, 0x01, 0x09, 0x55, 0x04, 0x73, 0x64, 0x6d, 0x2e, 0x78, 0x79, 0x7a})
+	f.Add([]byte{0xd1, 0x01, 0x05, 0x54, 0x82, 0x65, 0x6e, 0x00, 0x68})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		records, err := ParseNDEFMessage(data)
+		if err != nil {
+			return
+		}
+		for idx := range records {
+			records[idx].URI()
+			records[idx].Text()
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// NTAG21X_ORIGINALITY_KEY is NXP's public key (an uncompressed secp128r1
+// point, in hex) for the originality signatures of NTAG 213/215/216 chips.
+const NTAG21X_ORIGINALITY_KEY = "04494E1A386D3D3CFE3DC10E5DE68A499B1C202DB5B132393E89ED19FE5BE8BC61"
+
+// ParseNTAG21xMirror decodes the ASCII mirror of an NTAG 213/215/216 chip.
+// This is the UID (14 hex characters), the NFC counter (6 hex characters),
+// or both separated by an "x" (such as "04E141124C2880x000001").  Fields
+// which are not mirrored are -1.
+func ParseNTAG21xMirror(mirror string) (Meta, error) {
+	var uidStr, counterStr string
+	switch len(mirror) {
+	case 14:
+		uidStr = mirror
+	case 6:
+		counterStr = mirror
+	case 21:
+		if mirror[14] != 'x' {
+			return Meta{}, fmt.Errorf("%w: 21-character NTAG 21x mirror needs an x between the UID and counter, found %q", ErrBadHex, mirror[14])
+		}
+		uidStr, counterStr = mirror[0:14], mirror[15:21]
+	default:
+		return Meta{}, fmt.Errorf("%w: NTAG 21x mirror is %d characters, expected 14, 6, or 21", ErrWrongLength, len(mirror))
+	}
+	return decodePlainMirror(uidStr, counterStr)
+}
+
+// VerifyNTAG21xMirror decodes the ASCII mirror of an NTAG 213/215/216 chip
+// into a Result, so these chips can be handled along with SUN messages.
+// These mirrors have no MAC, so the Result is marked Unauthenticated and is
+// never Validated: anyone can make up a mirror for any UID and counter.
+// (The originality signature only shows that a chip with that UID exists.)
+func VerifyNTAG21xMirror(mirror string) (Result, error) {
+	meta, err := ParseNTAG21xMirror(mirror)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Meta:            meta,
+		Unauthenticated: true,
+	}, nil
+}
+
+// VerifyNTAG21xOriginalitySignature checks the 32-byte originality
+// signature (returned by READ_SIG) of an NTAG 213/215/216 chip with the given
+// UID, using NXP's public key.
+func VerifyNTAG21xOriginalitySignature(uid []byte, signature []byte) (bool, error) {
+	publicKey, _ := hex.DecodeString(NTAG21X_ORIGINALITY_KEY)
+	return VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid, signature)
+}
+
+// VerifyNTAG21xOriginalitySignatureWithKey is like
+// VerifyNTAG21xOriginalitySignature, but uses the given public key (an
+// uncompressed secp128r1 point).  The signature is r followed by s (16 bytes
+// each), and is over the UID itself (which is not hashed).
+func VerifyNTAG21xOriginalitySignatureWithKey(publicKey []byte, uid []byte, signature []byte) (bool, error) {
+	point, ok := secp128r1.unmarshal(publicKey)
+	if !ok {
+		return false, fmt.Errorf("%w: not an uncompressed secp128r1 point", ErrInvalidPublicKey)
+	}
+	if len(signature) != 32 {
+		return false, fmt.Errorf("%w: signature is %d bytes, expected 32", ErrWrongLength, len(signature))
+	}
+	if len(uid) != 7 {
+		return false, fmt.Errorf("%w: UID is %d bytes, expected 7", ErrWrongLength, len(uid))
+	}
+
+	r := new(big.Int).SetBytes(signature[0:16])
+	s := new(big.Int).SetBytes(signature[16:32])
+	return secp128r1.verify(point, uid, r, s), nil
+}
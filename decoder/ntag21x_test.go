@@ -0,0 +1,178 @@
+package decoder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseNTAG21xMirror(t *testing.T) {
+	tests := []struct {
+		mirror  string
+		uid     string
+		counter int32
+	}{
+		{"04E141124C2880x00002A", "04e141124c2880", 42},
+		{"04E141124C2880x000000", "04e141124c2880", 0},
+		{"04E141124C2880", "04e141124c2880", -1},
+		{"01F203", "", 0x01F203},
+	}
+	for _, test := range tests {
+		meta, err := ParseNTAG21xMirror(test.mirror)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.mirror, err)
+			continue
+		}
+		if test.uid == "" {
+			if meta.Uid != -1 {
+				t.Errorf("UID of %s should not have been mirrored, received %d", test.mirror, meta.Uid)
+			}
+		} else if meta.UidHex() != test.uid {
+			t.Errorf("Incorrect UID for %s. Expected %s, received %s", test.mirror, test.uid, meta.UidHex())
+		}
+		if meta.ReadCounter != test.counter {
+			t.Errorf("Incorrect counter for %s. Expected %d, received %d", test.mirror, test.counter, meta.ReadCounter)
+		}
+	}
+
+	errorcases := []struct {
+		mirror string
+		err    error
+	}{
+		{"", ErrWrongLength},
+		{"04E141124C2880y00002A", ErrBadHex},
+		{"04E141124C2880x00002", ErrWrongLength},
+		{"04E141124C28ZZ", ErrBadHex},
+	}
+	for _, errorcase := range errorcases {
+		if _, err := ParseNTAG21xMirror(errorcase.mirror); !errors.Is(err, errorcase.err) {
+			t.Errorf("Expected %v for %q, received %v", errorcase.err, errorcase.mirror, err)
+		}
+	}
+}
+
+func TestVerifyNTAG21xMirror(t *testing.T) {
+	result, err := VerifyNTAG21xMirror("04E141124C2880x00002A")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Validated || !result.Unauthenticated {
+		t.Errorf("NTAG 21x mirror should be unauthenticated and not validated")
+	}
+	if result.Meta.ReadCounter != 42 {
+		t.Errorf("Incorrect counter. Expected 42, received %d", result.Meta.ReadCounter)
+	}
+}
+
+// signSecp128r1 signs a message for tests (chips sign their UIDs at the factory).
+func signSecp128r1(t *testing.T, privateKey *big.Int, message []byte) []byte {
+	curve := secp128r1
+	e := new(big.Int).SetBytes(message)
+	if excess := len(message)*8 - curve.n.BitLen(); excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+	for {
+		k, err := rand.Int(rand.Reader, curve.n)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if k.Sign() == 0 {
+			continue
+		}
+		r := new(big.Int).Mod(curve.multiply(curve.g, k).x, curve.n)
+		if r.Sign() == 0 {
+			continue
+		}
+		s := new(big.Int).Mul(r, privateKey)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, curve.n))
+		s.Mod(s, curve.n)
+		if s.Sign() == 0 {
+			continue
+		}
+		signature := make([]byte, 32)
+		r.FillBytes(signature[0:16])
+		s.FillBytes(signature[16:32])
+		return signature
+	}
+}
+
+func TestSecp128r1(t *testing.T) {
+	if !secp128r1.onCurve(secp128r1.g) {
+		t.Errorf("Generator is not on the curve")
+	}
+	if point := secp128r1.multiply(secp128r1.g, secp128r1.n); point.x != nil {
+		t.Errorf("Generator does not have order n")
+	}
+	nxpKey, _ := hex.DecodeString(NTAG21X_ORIGINALITY_KEY)
+	if _, ok := secp128r1.unmarshal(nxpKey); !ok {
+		t.Errorf("NXP's public key is not on the curve")
+	}
+}
+
+func TestEcCurveNXPSignature(t *testing.T) {
+	// The same verification on P-224 accepts the real NXP signature from AN12196
+	p224 := secp224r1
+	nxpKey, _ := hex.DecodeString(NTAG424_ORIGINALITY_KEY)
+	point, ok := p224.unmarshal(nxpKey)
+	if !ok {
+		t.Fatalf("NXP's NTAG 424 DNA key is not on P-224")
+	}
+	uid, _ := hex.DecodeString("04518DFAA96180")
+	signature, _ := hex.DecodeString("D1940D17CFEDA4BFF80359AB975F9F6514313E8F90C1D3CAAF5941AD744A1CDF9A83F883CAFE0FE95D1939B1B7E47113993324473B785D21")
+	r := new(big.Int).SetBytes(signature[0:28])
+	s := new(big.Int).SetBytes(signature[28:56])
+	if !p224.verify(point, uid, r, s) {
+		t.Errorf("AN12196 signature not valid, but should have been")
+	}
+	if p224.verify(point, uid, s, r) {
+		t.Errorf("Swapped AN12196 signature valid")
+	}
+}
+
+func TestVerifyNTAG21xOriginalitySignature(t *testing.T) {
+	privateKey, err := rand.Int(rand.Reader, secp128r1.n)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	point := secp128r1.multiply(secp128r1.g, privateKey)
+	publicKey := make([]byte, 33)
+	publicKey[0] = 0x04
+	point.x.FillBytes(publicKey[1:17])
+	point.y.FillBytes(publicKey[17:33])
+
+	uid, _ := hex.DecodeString("04E141124C2880")
+	signature := signSecp128r1(t, privateKey, uid)
+
+	valid, err := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid, signature)
+	if err != nil || !valid {
+		t.Errorf("Signature not valid, but should have been (%v)", err)
+	}
+	otherUid, _ := hex.DecodeString("04E141124C2881")
+	if valid, _ := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, otherUid, signature); valid {
+		t.Errorf("Signature valid for the wrong UID")
+	}
+	signature[5] ^= 1
+	if valid, _ := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid, signature); valid {
+		t.Errorf("Tampered signature valid")
+	}
+	signature[5] ^= 1
+
+	valid, err = VerifyNTAG21xOriginalitySignature(uid, signature)
+	if err != nil || valid {
+		t.Errorf("Signature valid for NXP's key (%v)", err)
+	}
+
+	if _, err := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid, signature[0:31]); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+	if _, err := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid[0:4], signature); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+	publicKey[20] ^= 1
+	if _, err := VerifyNTAG21xOriginalitySignatureWithKey(publicKey, uid, signature); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected invalid public key error, received %v", err)
+	}
+}
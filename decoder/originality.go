@@ -0,0 +1,69 @@
+package decoder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// NTAG424_ORIGINALITY_KEY is NXP's public key (an uncompressed secp224r1
+// point, in hex) for the originality signatures of NTAG 424 DNA chips, from AN12196.
+const NTAG424_ORIGINALITY_KEY = "048A9B380AF2EE1B98DC417FECC263F8449C7625CECE82D9B916C992DA209D68422B81EC20B65A66B5102A61596AF3379200599316A00A1410"
+
+// secp224r1 (NIST P-224) is only used here to check public keys, since
+// elliptic.Unmarshal is deprecated and crypto/ecdh does not provide P-224.
+var secp224r1 = newP224Curve()
+
+func newP224Curve() *ecCurve {
+	params := elliptic.P224().Params()
+	return &ecCurve{
+		p:    params.P,
+		a:    new(big.Int).Sub(params.P, big.NewInt(3)),
+		b:    params.B,
+		g:    ecPoint{x: params.Gx, y: params.Gy},
+		n:    params.N,
+		size: 28,
+	}
+}
+
+// VerifyOriginalitySignature checks the originality signature (the 56 bytes
+// returned by Read_Sig) of an NTAG 424 DNA chip with the given UID, using NXP's
+// public key.  A valid signature shows that the chip was made by NXP, so it
+// can be checked when registering tags to reject counterfeits.
+func VerifyOriginalitySignature(uid []byte, signature []byte) (bool, error) {
+	publicKey, _ := hex.DecodeString(NTAG424_ORIGINALITY_KEY)
+	return VerifyOriginalitySignatureWithKey(publicKey, uid, signature)
+}
+
+// VerifyOriginalitySignatureWithKey is like VerifyOriginalitySignature, but
+// uses the given public key (an uncompressed secp224r1 point).
+//
+// The signature is r followed by s (28 bytes each), and is over the UID
+// itself (which is not hashed).  secp224r1 is the same curve as NIST P-224.
+func VerifyOriginalitySignatureWithKey(publicKey []byte, uid []byte, signature []byte) (bool, error) {
+	point, ok := secp224r1.unmarshal(publicKey)
+	if !ok {
+		return false, fmt.Errorf("%w: not an uncompressed secp224r1 point", ErrInvalidPublicKey)
+	}
+	if len(signature) != 56 {
+		return false, fmt.Errorf("%w: signature is %d bytes, expected 56", ErrWrongLength, len(signature))
+	}
+	if len(uid) != 7 {
+		return false, fmt.Errorf("%w: UID is %d bytes, expected 7", ErrWrongLength, len(uid))
+	}
+
+	r := new(big.Int).SetBytes(signature[0:28])
+	s := new(big.Int).SetBytes(signature[28:56])
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P224(), X: point.x, Y: point.y}, uid, r, s), nil
+}
+
+// VerifyOriginalitySignature checks the originality signature of the chip
+// which generated the message.  See VerifyOriginalitySignature.
+func (meta *Meta) VerifyOriginalitySignature(signature []byte) (bool, error) {
+	if !meta.uidMirrored() {
+		return false, ErrNoUID
+	}
+	return VerifyOriginalitySignature(meta.UidBytes(), signature)
+}
@@ -0,0 +1,97 @@
+package decoder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestVerifyOriginalitySignature(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	publicKey := make([]byte, 57)
+	publicKey[0] = 0x04
+	privateKey.X.FillBytes(publicKey[1:29])
+	privateKey.Y.FillBytes(publicKey[29:57])
+	uid, _ := hex.DecodeString("04518DFAA96180")
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, uid)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	signature := make([]byte, 56)
+	r.FillBytes(signature[0:28])
+	s.FillBytes(signature[28:56])
+
+	valid, err := VerifyOriginalitySignatureWithKey(publicKey, uid, signature)
+	if err != nil || !valid {
+		t.Errorf("Signature not valid, but should have been (%v)", err)
+	}
+
+	otherUid, _ := hex.DecodeString("04518DFAA96181")
+	if valid, _ := VerifyOriginalitySignatureWithKey(publicKey, otherUid, signature); valid {
+		t.Errorf("Signature valid for the wrong UID")
+	}
+	signature[10] ^= 1
+	if valid, _ := VerifyOriginalitySignatureWithKey(publicKey, uid, signature); valid {
+		t.Errorf("Tampered signature valid")
+	}
+
+	// Other chips' signatures aren't valid for NXP's key
+	signature[10] ^= 1
+	valid, err = VerifyOriginalitySignature(uid, signature)
+	if err != nil || valid {
+		t.Errorf("Signature valid for NXP's key (%v)", err)
+	}
+	meta := Meta{Uid: -1}
+	if _, err := meta.VerifyOriginalitySignature(signature); !errors.Is(err, ErrNoUID) {
+		t.Errorf("Expected no UID error, received %v", err)
+	}
+
+	if _, err := VerifyOriginalitySignatureWithKey(publicKey, uid, signature[0:55]); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+	if _, err := VerifyOriginalitySignatureWithKey(publicKey, uid[0:4], signature); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+	publicKey[20] ^= 1
+	if _, err := VerifyOriginalitySignatureWithKey(publicKey, uid, signature); !errors.Is(err, ErrInvalidPublicKey) {
+		t.Errorf("Expected invalid public key error, received %v", err)
+	}
+}
+
+func TestNTAG424OriginalitySignature(t *testing.T) {
+	// The example from AN12196
+	uid, _ := hex.DecodeString("04518DFAA96180")
+	signature, _ := hex.DecodeString("D1940D17CFEDA4BFF80359AB975F9F6514313E8F90C1D3CAAF5941AD744A1CDF9A83F883CAFE0FE95D1939B1B7E47113993324473B785D21")
+	valid, err := VerifyOriginalitySignature(uid, signature)
+	if err != nil || !valid {
+		t.Errorf("AN12196 signature not valid, but should have been (%v)", err)
+	}
+
+	meta := NewMeta(0x8061a9fa8d5104, 0, nil)
+	valid, err = meta.VerifyOriginalitySignature(signature)
+	if err != nil || !valid {
+		t.Errorf("AN12196 signature not valid for the tag's Meta, but should have been (%v)", err)
+	}
+
+	signature[0] ^= 1
+	if valid, _ := VerifyOriginalitySignature(uid, signature); valid {
+		t.Errorf("Tampered AN12196 signature valid")
+	}
+}
+
+func TestNTAG424OriginalityKey(t *testing.T) {
+	// A mistyped key would almost certainly not be on the curve
+	publicKey, err := hex.DecodeString(NTAG424_ORIGINALITY_KEY)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := secp224r1.unmarshal(publicKey); !ok {
+		t.Errorf("NXP's key is not a secp224r1 point")
+	}
+}
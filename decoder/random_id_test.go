@@ -0,0 +1,87 @@
+package decoder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRandomID(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	keyset.RandomID = true
+	if err := keyset.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	result, err := keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if err != nil || !result.Validated {
+		t.Fatalf("Not validated (%v)", err)
+	}
+	if result.Meta.UidHex() != "0421272aaa6180" || !result.Meta.UIDFromPICCData {
+		t.Errorf("Wrong meta: %+v", result.Meta)
+	}
+	meta, _ := keyset.DecodeEncryptedMetaStringWithAuthenticator("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if !meta.UIDFromPICCData {
+		t.Errorf("Legacy decoding should have set UIDFromPICCData")
+	}
+
+	// Without Random ID, the UID is also the anticollision UID
+	result, _ = testVerifyKeyset(AES).Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if result.Meta.UIDFromPICCData {
+		t.Errorf("UIDFromPICCData should not be set without Random ID")
+	}
+
+	// Plain mirrors are not decoded
+	if _, err := keyset.Verify("0471862A506380000003", "637618472FE7D110"); err == nil {
+		t.Errorf("Expected an error for a plain mirror")
+	}
+	template, _ := ParseURLTemplate("https://x.example/t?u={uid}&c={ctr}&m={cmac}")
+	if _, err := keyset.VerifyURL(template, "https://x.example/t?u=0471862A506380&c=000003&m=637618472FE7D110"); !errors.Is(err, ErrRandomID) {
+		t.Errorf("Expected Random ID error, received %v", err)
+	}
+	layout := NewSDMLayout()
+	layout.UIDOffset = 0
+	layout.SDMReadCtrOffset = 14
+	layout.SDMMACInputOffset = 20
+	layout.SDMMACOffset = 20
+	if _, err := keyset.VerifyLayout(layout, []byte("0471862A506380000003637618472FE7D110")); !errors.Is(err, ErrRandomID) {
+		t.Errorf("Expected Random ID error, received %v", err)
+	}
+
+	keyset.MetaReadKey = KEY_NONE
+	if err := keyset.Validate(); !errors.Is(err, ErrRandomID) {
+		t.Errorf("Expected Random ID error, received %v", err)
+	}
+	if _, err := keyset.DecodeMetaString("0471862A506380000003"); !errors.Is(err, ErrRandomID) {
+		t.Errorf("Expected Random ID error, received %v", err)
+	}
+}
+
+func TestKeysetConfigRandomID(t *testing.T) {
+	config := "version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys:\n      - slot: 0\n        key: 00000000000000000000000000000000\n    meta_read_key: 0\n    random_id: true\n"
+	configs, err := ParseKeysetConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !configs[0].Keyset.RandomID {
+		t.Errorf("Random ID not set")
+	}
+	data, err := MarshalKeysetConfig(configs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reparsed, err := ParseKeysetConfig(data); err != nil || !reparsed[0].Keyset.RandomID {
+		t.Errorf("Random ID not written (%v):\n%s", err, data)
+	}
+
+	invalid := []string{
+		"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    random_id: true\n",
+		"version: 1\nkeysets:\n  - name: a\n    mode: AES\n    keys: []\n    random_id: yes please\n",
+		config + "    url_template: https://x.example/t?u={uid}&c={ctr}&m={cmac}\n",
+		config + "    layout:\n      uid_offset: 0\n      read_counter_offset: 14\n      mac_input_offset: 20\n      mac_offset: 20\n",
+	}
+	for idx, testcase := range invalid {
+		if _, err := ParseKeysetConfig([]byte(testcase)); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Testcase %d: expected a configuration error, received %v", idx, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Verdict is the outcome of checking a message for replays.
+type Verdict int
+
+const (
+	// VERDICT_INVALID means the message was not authentic.
+	VERDICT_INVALID Verdict = iota
+	// VERDICT_REPLAYED means the message was authentic, but its read counter was not
+	// higher than the last accepted one, so it was probably copied.
+	VERDICT_REPLAYED Verdict = iota
+	// VERDICT_ACCEPTED means the message was authentic and fresh.
+	VERDICT_ACCEPTED Verdict = iota
+)
+
+// String gives the name of the verdict.
+func (verdict Verdict) String() string {
+	switch verdict {
+	case VERDICT_INVALID:
+		return "invalid"
+	case VERDICT_REPLAYED:
+		return "replayed"
+	case VERDICT_ACCEPTED:
+		return "accepted"
+	default:
+		return fmt.Sprintf("Verdict(%d)", int(verdict))
+	}
+}
+
+// ReplayGuard rejects messages whose read counter is not higher than the
+// last one accepted for the same UID.  Since a validated MAC covers the read
+// counter, this detects URLs which have been copied and used again.
+//
+// Messaging apps and browsers often fetch a URL several times within a few
+// seconds (e.g., for link previews).  If PrefetchWindow is set, a message
+// identical (same UID, read counter, and MAC) to the last one accepted for
+// its UID is accepted again for that long after it was first accepted.  Once a
+// newer message is accepted, older ones are rejected as usual.  This is kept
+// in memory, so it only applies to messages checked by the same ReplayGuard.
+type ReplayGuard struct {
+	Store          CounterStore
+	PrefetchWindow time.Duration
+	// Now gives the current time for PrefetchWindow (time.Now if nil).
+	Now func() time.Time
+
+	// uidLocks serialize checks of messages from the same UID (by hash), and
+	// mutex protects accepted.
+	uidLocks  [replayGuardLocks]sync.Mutex
+	mutex     sync.Mutex
+	accepted  map[string]acceptedMessage
+	sweepSize int
+}
+
+// replayGuardLocks is the number of locks checks are spread over.
+const replayGuardLocks = 64
+
+// acceptedMessage is the last message accepted for a UID.
+type acceptedMessage struct {
+	readCounter int32
+	mac         []byte
+	acceptedAt  time.Time
+}
+
+// NewReplayGuard creates a ReplayGuard using the given store.
+func NewReplayGuard(store CounterStore) *ReplayGuard {
+	return &ReplayGuard{
+		Store: store,
+	}
+}
+
+// Check checks a verification result for replays, recording its read counter if it is accepted.
+// Results which are not validated are never recorded.  This is safe for concurrent use, and if
+// the same message is checked concurrently, only one will be accepted (unless PrefetchWindow is
+// set, in which case they are all accepted).
+func (guard *ReplayGuard) Check(result Result) (Verdict, error) {
+	if !result.Validated {
+		return VERDICT_INVALID, nil
+	}
+	if !result.Meta.readCounterMirrored() {
+		return VERDICT_INVALID, ErrNoReadCounter
+	}
+	if !result.Meta.uidMirrored() {
+		return VERDICT_INVALID, ErrNoUID
+	}
+
+	if guard.PrefetchWindow <= 0 || len(result.MAC) == 0 {
+		return guard.check(result)
+	}
+
+	// Holding the UID's lock keeps a concurrent copy of the message from
+	// being checked between accepting it and remembering it.
+	uid := result.Meta.UidHex()
+	uidLock := guard.uidLock(uid)
+	uidLock.Lock()
+	defer uidLock.Unlock()
+
+	now := guard.now()
+	verdict, err := guard.check(result)
+	if err != nil {
+		return verdict, err
+	}
+	if verdict == VERDICT_ACCEPTED {
+		guard.remember(uid, acceptedMessage{
+			readCounter: result.Meta.ReadCounter,
+			mac:         result.MAC,
+			acceptedAt:  now,
+		}, now)
+		return verdict, nil
+	}
+
+	guard.mutex.Lock()
+	last, ok := guard.accepted[uid]
+	guard.mutex.Unlock()
+	if ok && last.readCounter == result.Meta.ReadCounter && bytes.Equal(last.mac, result.MAC) && now.Sub(last.acceptedAt) < guard.PrefetchWindow {
+		// Make sure it is still the last accepted message in the store
+		lastCounter, err := guard.Store.Load(uid)
+		if err != nil {
+			return VERDICT_INVALID, err
+		}
+		if lastCounter == result.Meta.ReadCounter {
+			return VERDICT_ACCEPTED, nil
+		}
+	}
+	return verdict, nil
+}
+
+func (guard *ReplayGuard) check(result Result) (Verdict, error) {
+	uid := result.Meta.UidHex()
+	for {
+		lastCounter, err := guard.Store.Load(uid)
+		if err != nil {
+			return VERDICT_INVALID, err
+		}
+		if result.Meta.ReadCounter <= lastCounter {
+			return VERDICT_REPLAYED, nil
+		}
+		swapped, err := guard.Store.CompareAndSwap(uid, lastCounter, result.Meta.ReadCounter)
+		if err != nil {
+			return VERDICT_INVALID, err
+		}
+		if swapped {
+			return VERDICT_ACCEPTED, nil
+		}
+	}
+}
+
+// uidLock gives the lock for checking messages from the UID.
+func (guard *ReplayGuard) uidLock(uid string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(uid))
+	return &guard.uidLocks[hash.Sum32()%replayGuardLocks]
+}
+
+func (guard *ReplayGuard) now() time.Time {
+	if guard.Now == nil {
+		return time.Now()
+	}
+	return guard.Now()
+}
+
+// remember records the last accepted message for a UID.  Expired messages are
+// removed whenever the number remembered doubles.
+func (guard *ReplayGuard) remember(uid string, message acceptedMessage, now time.Time) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	if guard.accepted == nil {
+		guard.accepted = map[string]acceptedMessage{}
+	}
+	guard.accepted[uid] = message
+
+	if len(guard.accepted) > 2*guard.sweepSize {
+		for uid, message := range guard.accepted {
+			if now.Sub(message.acceptedAt) >= guard.PrefetchWindow {
+				delete(guard.accepted, uid)
+			}
+		}
+		guard.sweepSize = len(guard.accepted)
+	}
+}
@@ -0,0 +1,213 @@
+package decoder
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayGuard(t *testing.T) {
+	guard := NewReplayGuard(NewMemoryCounterStore())
+	keyset := testVerifyKeyset(AES)
+
+	result, _ := keyset.Verify("0471862A506380000003", "637618472FE7D110")
+	verdict, err := guard.Check(result)
+	if err != nil || verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s (%v)", verdict, err)
+	}
+	verdict, err = guard.Check(result)
+	if err != nil || verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s (%v)", verdict, err)
+	}
+
+	result, _ = keyset.Verify("0471862A506380000003", "637618472FE7D111")
+	verdict, err = guard.Check(result)
+	if err != nil || verdict != VERDICT_INVALID {
+		t.Errorf("Expected invalid, received %s (%v)", verdict, err)
+	}
+
+	// Lower counters are replays, higher counters are accepted
+	result, _ = keyset.Verify("0471862A506380000003", "637618472FE7D110")
+	result.Meta.ReadCounter = 2
+	if verdict, _ := guard.Check(result); verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s", verdict)
+	}
+	result.Meta.ReadCounter = 10
+	if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+
+	// Other UIDs are independent
+	result, _ = keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+
+	result.Meta.ReadCounter = -1
+	result.Meta.HasReadCounter = false
+	if _, err := guard.Check(result); !errors.Is(err, ErrNoReadCounter) {
+		t.Errorf("Expected no read counter error, received %v", err)
+	}
+	result.Meta.HasReadCounter = true
+	result.Meta.HasUid = false
+	if _, err := guard.Check(result); !errors.Is(err, ErrNoUID) {
+		t.Errorf("Expected no UID error, received %v", err)
+	}
+}
+
+func TestReplayGuardConcurrent(t *testing.T) {
+	guard := NewReplayGuard(NewMemoryCounterStore())
+	result, _ := testVerifyKeyset(AES).Verify("0471862A506380000003", "637618472FE7D110")
+
+	for counter := int32(1); counter < 20; counter++ {
+		result.Meta.ReadCounter = counter
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		accepted := 0
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				verdict, _ := guard.Check(result)
+				if verdict == VERDICT_ACCEPTED {
+					mutex.Lock()
+					accepted++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if accepted != 1 {
+			t.Errorf("Counter %d accepted %d times", counter, accepted)
+		}
+	}
+}
+
+func TestReplayGuardPrefetchWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	guard := NewReplayGuard(NewMemoryCounterStore())
+	guard.PrefetchWindow = 10 * time.Second
+	guard.Now = func() time.Time {
+		return now
+	}
+	keyset := testVerifyKeyset(AES)
+
+	result, _ := keyset.Verify("0471862A506380000003", "637618472FE7D110")
+	if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+
+	// Prefetches of the same URL are accepted
+	now = now.Add(2 * time.Second)
+	if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	now = now.Add(5 * time.Second)
+	if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+
+	// A different MAC for the same counter is not the same message
+	other := result
+	other.MAC = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if verdict, _ := guard.Check(other); verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s", verdict)
+	}
+
+	// The window runs from the first acceptance
+	now = now.Add(3 * time.Second)
+	if verdict, _ := guard.Check(result); verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s", verdict)
+	}
+
+	// Once a newer message is accepted, older ones are rejected within the window
+	newer := result
+	newer.Meta.ReadCounter = 4
+	if verdict, _ := guard.Check(newer); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	newest := result
+	newest.Meta.ReadCounter = 5
+	if verdict, _ := guard.Check(newest); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	if verdict, _ := guard.Check(newer); verdict != VERDICT_REPLAYED {
+		t.Errorf("Expected replayed, received %s", verdict)
+	}
+
+	// Invalid messages are never accepted
+	invalid, _ := keyset.Verify("0471862A506380000005", "637618472FE7D110")
+	if verdict, _ := guard.Check(invalid); verdict != VERDICT_INVALID {
+		t.Errorf("Expected invalid, received %s", verdict)
+	}
+}
+
+func TestReplayGuardPrefetchWindowConcurrent(t *testing.T) {
+	guard := NewReplayGuard(NewMemoryCounterStore())
+	guard.PrefetchWindow = time.Minute
+	result, _ := testVerifyKeyset(AES).Verify("0471862A506380000003", "637618472FE7D110")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if verdict, _ := guard.Check(result); verdict != VERDICT_ACCEPTED {
+				t.Errorf("Expected accepted, received %s", verdict)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// blockingCounterStore blocks loading one UID until released.
+type blockingCounterStore struct {
+	CounterStore
+	uid     string
+	loading chan bool
+	release chan bool
+}
+
+func (store *blockingCounterStore) Load(uid string) (int32, error) {
+	if uid == store.uid {
+		select {
+		case store.loading <- true:
+		default:
+		}
+		<-store.release
+	}
+	return store.CounterStore.Load(uid)
+}
+
+func TestReplayGuardPrefetchWindowIndependentUIDs(t *testing.T) {
+	slow, _ := testVerifyKeyset(AES).Verify("0471862A506380000003", "637618472FE7D110")
+	fast, _ := testVerifyKeyset(AES).Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	store := &blockingCounterStore{
+		CounterStore: NewMemoryCounterStore(),
+		uid:          slow.Meta.UidHex(),
+		loading:      make(chan bool, 1),
+		release:      make(chan bool),
+	}
+	guard := NewReplayGuard(store)
+	guard.PrefetchWindow = time.Minute
+	if guard.uidLock(slow.Meta.UidHex()) == guard.uidLock(fast.Meta.UidHex()) {
+		t.Fatalf("Test UIDs share a lock")
+	}
+
+	done := make(chan Verdict)
+	go func() {
+		verdict, _ := guard.Check(slow)
+		done <- verdict
+	}()
+	<-store.loading
+
+	// Another UID isn't held up by the slow store
+	if verdict, _ := guard.Check(fast); verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+	close(store.release)
+	if verdict := <-done; verdict != VERDICT_ACCEPTED {
+		t.Errorf("Expected accepted, received %s", verdict)
+	}
+}
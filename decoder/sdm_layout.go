@@ -0,0 +1,233 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// OFFSET_NONE marks a field which the chip does not mirror.
+const OFFSET_NONE = -1
+
+// SDMLayout holds the offsets of the mirrored fields in the NDEF file, as
+// configured in the chip's SDM file settings.  Offsets are relative to the
+// start of the file (i.e., they include the 2-byte NLEN field).  Create one
+// with NewSDMLayout, since a zero offset is a valid offset.
+type SDMLayout struct {
+	// UIDOffset and SDMReadCtrOffset are used for plain (unencrypted) mirroring.
+	UIDOffset        int
+	SDMReadCtrOffset int
+	// PICCDataOffset is used for encrypted PICCData.
+	PICCDataOffset    int
+	SDMMACInputOffset int
+	SDMMACOffset      int
+	SDMENCOffset      int
+	// SDMENCLength is the number of ASCII characters of mirrored file data.
+	SDMENCLength int
+	// TTStatusOffset is where NTAG 424 DNA TT chips mirror their tamper status (2 characters).
+	TTStatusOffset int
+}
+
+// SDMFields are the ASCII (hex) fields found using an SDMLayout.
+// Fields which are not mirrored are empty.
+type SDMFields struct {
+	UID         string
+	ReadCounter string
+	PICCData    string
+	EncFileData string
+	MAC         string
+	TTStatus    string
+	// MACInput is the data the chip MACs.
+	MACInput []byte
+}
+
+// NewSDMLayout creates a layout with no mirrored fields.
+func NewSDMLayout() SDMLayout {
+	return SDMLayout{
+		UIDOffset:         OFFSET_NONE,
+		SDMReadCtrOffset:  OFFSET_NONE,
+		PICCDataOffset:    OFFSET_NONE,
+		SDMMACInputOffset: OFFSET_NONE,
+		SDMMACOffset:      OFFSET_NONE,
+		SDMENCOffset:      OFFSET_NONE,
+		SDMENCLength:      0,
+		TTStatusOffset:    OFFSET_NONE,
+	}
+}
+
+// NDEFFileFromURL builds the NDEF file a chip would hold for the URL
+// (a single URI record, using the longest matching URI prefix abbreviation).
+// Use this to apply a layout to the URL a phone opened.
+func NDEFFileFromURL(url string) []byte {
+	code, _ := AbbreviateURI(url)
+	file, _ := NDEFFileFromURLWithPrefix(url, code)
+	return file
+}
+
+// NDEFFileFromURLWithPrefix is like NDEFFileFromURL, but uses the given URI
+// prefix code, in case the chip was not written using the longest prefix.
+func NDEFFileFromURLWithPrefix(url string, code byte) ([]byte, error) {
+	if int(code) >= len(URIPrefixes) {
+		return nil, fmt.Errorf("%w: unknown URI prefix code %d", ErrInvalidTemplate, code)
+	}
+	prefix := URIPrefixes[code]
+	if len(url) < len(prefix) || url[0:len(prefix)] != prefix {
+		return nil, fmt.Errorf("%w: URL does not start with %s", ErrInvalidTemplate, prefix)
+	}
+	payload := append([]byte{code}, url[len(prefix):]...)
+
+	var record []byte
+	if len(payload) < 256 {
+		// MB, ME, SR, TNF = well-known
+		record = []byte{0xd1, 0x01, byte(len(payload))}
+	} else {
+		// MB, ME, TNF = well-known
+		record = []byte{0xc1, 0x01, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(record[2:6], uint32(len(payload)))
+	}
+	record = append(record, 'U')
+	record = append(record, payload...)
+
+	return NDEFFileFromMessage(record), nil
+}
+
+// Extract finds the mirrored fields in the NDEF file.  The mode
+// determines the length of the encrypted PICCData.
+func (layout *SDMLayout) Extract(file []byte, mode EncryptionMode) (SDMFields, error) {
+	fields := SDMFields{}
+	var err error
+
+	piccDataLength := 32
+	if mode == LRP {
+		piccDataLength = 48
+	}
+
+	if fields.UID, err = layoutField(file, "UID", layout.UIDOffset, 14); err != nil {
+		return SDMFields{}, err
+	}
+	if fields.ReadCounter, err = layoutField(file, "SDMReadCtr", layout.SDMReadCtrOffset, 6); err != nil {
+		return SDMFields{}, err
+	}
+	if fields.PICCData, err = layoutField(file, "PICCData", layout.PICCDataOffset, piccDataLength); err != nil {
+		return SDMFields{}, err
+	}
+	if fields.EncFileData, err = layoutField(file, "SDMENCFileData", layout.SDMENCOffset, layout.SDMENCLength); err != nil {
+		return SDMFields{}, err
+	}
+	if fields.MAC, err = layoutField(file, "SDMMAC", layout.SDMMACOffset, 16); err != nil {
+		return SDMFields{}, err
+	}
+	if fields.TTStatus, err = layoutField(file, "TTStatus", layout.TTStatusOffset, 2); err != nil {
+		return SDMFields{}, err
+	}
+	if layout.SDMMACOffset != OFFSET_NONE {
+		if layout.SDMMACInputOffset < 0 || layout.SDMMACInputOffset > layout.SDMMACOffset {
+			return SDMFields{}, fmt.Errorf("%w: SDMMACInputOffset %d is not before SDMMACOffset %d", ErrWrongLength, layout.SDMMACInputOffset, layout.SDMMACOffset)
+		}
+		fields.MACInput = file[layout.SDMMACInputOffset:layout.SDMMACOffset]
+	}
+
+	return fields, nil
+}
+
+// layoutField reads a field of the given length at offset.
+func layoutField(file []byte, name string, offset int, length int) (string, error) {
+	if offset == OFFSET_NONE {
+		return "", nil
+	}
+	if offset < 0 || length < 0 || offset > len(file)-length {
+		return "", fmt.Errorf("%w: %s at offset %d does not fit in %d bytes", ErrWrongLength, name, offset, len(file))
+	}
+	return string(file[offset:(offset + length)]), nil
+}
+
+// VerifyLayoutURL verifies the URL a phone opened using the chip's offsets.
+// See VerifyLayout.
+func (keyset *Keyset) VerifyLayoutURL(layout SDMLayout, url string) (Result, error) {
+	return keyset.VerifyLayout(layout, NDEFFileFromURL(url))
+}
+
+// VerifyLayout verifies the contents of the NDEF file using the chip's
+// offsets.  The Meta is read either from the PICCData or from the plain
+// UID and read counter mirrors.  If the layout has a MAC, it is validated
+// over the SDMMACInput range, and if it has encrypted file data, it is
+// decrypted into the Result's FileData.  If it has a tamper status, it is
+// given in the Result's TamperStatus.
+func (keyset *Keyset) VerifyLayout(layout SDMLayout, file []byte) (Result, error) {
+	fields, err := layout.Extract(file, keyset.Mode)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var meta Meta
+	if layout.PICCDataOffset != OFFSET_NONE {
+		meta, err = keyset.DecodeMetaString(fields.PICCData)
+	} else if keyset.RandomID {
+		return Result{}, fmt.Errorf("%w: layout has plain UID and read counter mirrors", ErrRandomID)
+	} else {
+		meta, err = decodePlainMirror(fields.UID, fields.ReadCounter)
+		meta.Keyset = keyset
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Meta: meta,
+	}
+
+	if layout.SDMMACOffset != OFFSET_NONE {
+		result.Validated, err = meta.ValidateMirroredMAC(file, layout.SDMMACInputOffset, layout.SDMMACOffset)
+		if err != nil {
+			return Result{}, err
+		}
+		result.MAC = mirroredMAC(file, layout.SDMMACOffset)
+	}
+
+	if layout.TTStatusOffset != OFFSET_NONE {
+		authenticated := layout.SDMMACOffset != OFFSET_NONE && layout.TTStatusOffset >= layout.SDMMACInputOffset && layout.TTStatusOffset+2 <= layout.SDMMACOffset
+		result.TamperStatus, err = parseMirroredTamperStatus(fields.TTStatus, result.Validated && authenticated)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	if layout.SDMENCOffset != OFFSET_NONE {
+		encFileData, err := hex.DecodeString(fields.EncFileData)
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: file data: %v", ErrBadHex, err)
+		}
+		result.FileData, err = keyset.DecryptFileData(meta, encFileData)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// decodePlainMirror decodes the plain UID and read counter mirrors,
+// either of which may be empty.
+func decodePlainMirror(uidStr string, counterStr string) (Meta, error) {
+	data := []byte{0}
+	if uidStr != "" {
+		uid, err := hex.DecodeString(uidStr)
+		if err != nil {
+			return Meta{}, fmt.Errorf("%w: UID: %v", ErrBadHex, err)
+		}
+		data[0] |= 0b10000111
+		data = append(data, uid...)
+	}
+	if counterStr != "" {
+		counter, err := hex.DecodeString(counterStr)
+		if err != nil {
+			return Meta{}, fmt.Errorf("%w: SDMReadCtr: %v", ErrBadHex, err)
+		}
+		data[0] |= 0b01000000
+		// The mirror is MSB first, but PICCData is LSB first
+		for idx := len(counter) - 1; idx >= 0; idx-- {
+			data = append(data, counter[idx])
+		}
+	}
+
+	return ParsePICCData(data)
+}
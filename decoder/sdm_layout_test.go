@@ -0,0 +1,102 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNDEFFileFromURL(t *testing.T) {
+	file := NDEFFileFromURL("https://www.example.com/")
+	if hex.EncodeToString(file) != "0011d1010d5502"+hex.EncodeToString([]byte("example.com/")) {
+		t.Errorf("Bad NDEF file: %s", hex.EncodeToString(file))
+	}
+
+	file, err := NDEFFileFromURLWithPrefix("https://www.example.com/", 4)
+	if err != nil || hex.EncodeToString(file) != "0015d101115504"+hex.EncodeToString([]byte("www.example.com/")) {
+		t.Errorf("Bad NDEF file: %s (%v)", hex.EncodeToString(file), err)
+	}
+
+	if _, err := NDEFFileFromURLWithPrefix("https://www.example.com/", 3); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Expected invalid template error, received %v", err)
+	}
+	if _, err := NDEFFileFromURLWithPrefix("https://www.example.com/", 0xff); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Expected invalid template error, received %v", err)
+	}
+
+	longURL := "https://example.com/" + strings.Repeat("a", 300)
+	file = NDEFFileFromURL(longURL)
+	if hex.EncodeToString(file[0:9]) != "0140c10100000139"+"55" || file[9] != 4 {
+		t.Errorf("Bad long NDEF file header: %s", hex.EncodeToString(file[0:10]))
+	}
+}
+
+func TestSDMLayout(t *testing.T) {
+	url := "https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6"
+	// The file has NLEN, the record header, and the prefix code in place of "https://"
+	fileOffset := func(marker string) int {
+		return strings.Index(url, marker) + len(marker) + 7 - len("https://")
+	}
+	layout := NewSDMLayout()
+	layout.PICCDataOffset = fileOffset("picc_data=")
+	layout.SDMENCOffset = fileOffset("enc=")
+	layout.SDMENCLength = 32
+	layout.SDMMACInputOffset = layout.SDMENCOffset
+	layout.SDMMACOffset = fileOffset("cmac=")
+
+	keyset := Keyset{
+		Mode: AES,
+		Keys: []Key{
+			Key{
+				KeyData: make([]byte, 16),
+			},
+		},
+	}
+	result, err := keyset.VerifyLayoutURL(layout, url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if result.Meta.UidHex() != "04958caa5c5e80" || result.Meta.ReadCounter != 8 {
+		t.Errorf("Wrong meta: %s / %d", result.Meta.UidHex(), result.Meta.ReadCounter)
+	}
+	if string(result.FileData) != "xxxxxxxxxxxxxxxx" {
+		t.Errorf("Bad file data: %s", result.FileData)
+	}
+
+	fields, err := layout.Extract(NDEFFileFromURL(url), AES)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fields.PICCData != "FD91EC264309878BE6345CBE53BADF40" || fields.MAC != "ECC1E7F6C6C73BF6" || string(fields.MACInput) != "CEE9A53E3E463EF1F459635736738962&cmac=" {
+		t.Errorf("Bad fields: %+v", fields)
+	}
+
+	// A shorter URL than the layout expects
+	if _, err := keyset.VerifyLayoutURL(layout, url[0:100]); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+}
+
+func TestSDMLayoutPlainMirror(t *testing.T) {
+	url := "https://x.example/t?u=0471862A506380x000003&m=637618472FE7D110"
+	layout := NewSDMLayout()
+	layout.UIDOffset = strings.Index(url, "u=") + 2 + 7 - len("https://")
+	layout.SDMReadCtrOffset = layout.UIDOffset + 15
+	layout.SDMMACOffset = strings.Index(url, "m=") + 2 + 7 - len("https://")
+	layout.SDMMACInputOffset = layout.SDMMACOffset
+
+	result, err := testVerifyKeyset(AES).VerifyLayoutURL(layout, url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if result.Meta.UidHex() != "0471862a506380" || result.Meta.ReadCounter != 3 {
+		t.Errorf("Wrong meta: %s / %d", result.Meta.UidHex(), result.Meta.ReadCounter)
+	}
+}
@@ -0,0 +1,150 @@
+package decoder
+
+import (
+	"math/big"
+)
+
+// secp128r1 is the curve used for NTAG 21x originality signatures.  Go's
+// crypto/elliptic does not provide it, so the little ECDSA verification
+// needed is done here with math/big.  Nothing here handles secrets, so it
+// does not need to be constant-time.
+type ecPoint struct {
+	x *big.Int
+	y *big.Int
+}
+
+type ecCurve struct {
+	p *big.Int
+	a *big.Int
+	b *big.Int
+	g ecPoint
+	n *big.Int
+	// size is the length in bytes of coordinates and signature halves.
+	size int
+}
+
+func mustParseHexInt(str string) *big.Int {
+	value, ok := new(big.Int).SetString(str, 16)
+	if !ok {
+		panic("invalid hex integer: " + str)
+	}
+	return value
+}
+
+var secp128r1 = &ecCurve{
+	p: mustParseHexInt("FFFFFFFDFFFFFFFFFFFFFFFFFFFFFFFF"),
+	a: mustParseHexInt("FFFFFFFDFFFFFFFFFFFFFFFFFFFFFFFC"),
+	b: mustParseHexInt("E87579C11079F43DD824993C2CEE5ED3"),
+	g: ecPoint{
+		x: mustParseHexInt("161FF7528B899B2D0C28607CA52C5B86"),
+		y: mustParseHexInt("CF5AC8395BAFEB13C02DA292DDED7A83"),
+	},
+	n:    mustParseHexInt("FFFFFFFE0000000075A30D1B9038A115"),
+	size: 16,
+}
+
+// onCurve tells whether the point is on the curve (and not the point at infinity).
+func (curve *ecCurve) onCurve(point ecPoint) bool {
+	if point.x == nil || point.x.Sign() < 0 || point.x.Cmp(curve.p) >= 0 || point.y.Sign() < 0 || point.y.Cmp(curve.p) >= 0 {
+		return false
+	}
+	// y^2 = x^3 + ax + b
+	left := new(big.Int).Mul(point.y, point.y)
+	left.Mod(left, curve.p)
+	right := new(big.Int).Mul(point.x, point.x)
+	right.Add(right, curve.a)
+	right.Mul(right, point.x)
+	right.Add(right, curve.b)
+	right.Mod(right, curve.p)
+	return left.Cmp(right) == 0
+}
+
+// unmarshal parses an uncompressed point (0x04, x, y).
+func (curve *ecCurve) unmarshal(data []byte) (ecPoint, bool) {
+	if len(data) != 1+2*curve.size || data[0] != 0x04 {
+		return ecPoint{}, false
+	}
+	point := ecPoint{
+		x: new(big.Int).SetBytes(data[1:(1 + curve.size)]),
+		y: new(big.Int).SetBytes(data[(1 + curve.size):]),
+	}
+	return point, curve.onCurve(point)
+}
+
+// add adds two points.  A nil x is the point at infinity.
+func (curve *ecCurve) add(p1 ecPoint, p2 ecPoint) ecPoint {
+	if p1.x == nil {
+		return p2
+	}
+	if p2.x == nil {
+		return p1
+	}
+
+	var slope *big.Int
+	if p1.x.Cmp(p2.x) == 0 {
+		sum := new(big.Int).Add(p1.y, p2.y)
+		if sum.Mod(sum, curve.p).Sign() == 0 {
+			return ecPoint{}
+		}
+		// Doubling: (3x^2 + a) / 2y
+		slope = new(big.Int).Mul(p1.x, p1.x)
+		slope.Mul(slope, big.NewInt(3))
+		slope.Add(slope, curve.a)
+		denominator := new(big.Int).Lsh(p1.y, 1)
+		slope.Mul(slope, denominator.ModInverse(denominator, curve.p))
+	} else {
+		// (y2 - y1) / (x2 - x1)
+		slope = new(big.Int).Sub(p2.y, p1.y)
+		denominator := new(big.Int).Sub(p2.x, p1.x)
+		denominator.Mod(denominator, curve.p)
+		slope.Mul(slope, denominator.ModInverse(denominator, curve.p))
+	}
+	slope.Mod(slope, curve.p)
+
+	x := new(big.Int).Mul(slope, slope)
+	x.Sub(x, p1.x)
+	x.Sub(x, p2.x)
+	x.Mod(x, curve.p)
+	y := new(big.Int).Sub(p1.x, x)
+	y.Mul(y, slope)
+	y.Sub(y, p1.y)
+	y.Mod(y, curve.p)
+	return ecPoint{x: x, y: y}
+}
+
+// multiply multiplies a point by a scalar.
+func (curve *ecCurve) multiply(point ecPoint, scalar *big.Int) ecPoint {
+	result := ecPoint{}
+	for idx := scalar.BitLen() - 1; idx >= 0; idx-- {
+		result = curve.add(result, result)
+		if scalar.Bit(idx) == 1 {
+			result = curve.add(result, point)
+		}
+	}
+	return result
+}
+
+// verify checks an ECDSA signature (r, s) over a message which is used as
+// the hash directly (without hashing it).
+func (curve *ecCurve) verify(publicKey ecPoint, message []byte, r *big.Int, s *big.Int) bool {
+	if r.Sign() <= 0 || r.Cmp(curve.n) >= 0 || s.Sign() <= 0 || s.Cmp(curve.n) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(message)
+	if excess := len(message)*8 - curve.n.BitLen(); excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+	w := new(big.Int).ModInverse(s, curve.n)
+	u1 := new(big.Int).Mul(e, w)
+	u1.Mod(u1, curve.n)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, curve.n)
+
+	point := curve.add(curve.multiply(curve.g, u1), curve.multiply(publicKey, u2))
+	if point.x == nil {
+		return false
+	}
+	v := new(big.Int).Mod(point.x, curve.n)
+	return v.Cmp(r) == 0
+}
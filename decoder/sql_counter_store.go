@@ -0,0 +1,105 @@
+package decoder
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlCounterStoreMigrations are applied in order to bring the schema up to date.
+// The schema version is the number of migrations applied.
+var sqlCounterStoreMigrations = []string{
+	`CREATE TABLE read_counters (
+		uid TEXT NOT NULL PRIMARY KEY,
+		counter INTEGER NOT NULL
+	)`,
+	`ALTER TABLE read_counters ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+}
+
+// SQLCounterStore is a CounterStore which persists counters in an SQLite
+// database.  It only uses database/sql, so any SQLite driver can be used,
+// such as github.com/mattn/go-sqlite3 (an embedded SQLite).
+type SQLCounterStore struct {
+	db *sql.DB
+}
+
+// NewSQLCounterStore creates a store using the database, creating or
+// migrating its tables as needed.
+func NewSQLCounterStore(db *sql.DB) (*SQLCounterStore, error) {
+	store := &SQLCounterStore{
+		db: db,
+	}
+	if err := store.migrate(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrate applies any migrations which have not been applied yet.
+func (store *SQLCounterStore) migrate() error {
+	if _, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS read_counter_schema (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version := 0
+	err = tx.QueryRow(`SELECT version FROM read_counter_schema`).Scan(&version)
+	if err == sql.ErrNoRows {
+		if _, err := tx.Exec(`INSERT INTO read_counter_schema (version) VALUES (0)`); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if version > len(sqlCounterStoreMigrations) {
+		return fmt.Errorf("read counter schema version %d is newer than this library (%d)", version, len(sqlCounterStoreMigrations))
+	}
+
+	for ; version < len(sqlCounterStoreMigrations); version++ {
+		if _, err := tx.Exec(sqlCounterStoreMigrations[version]); err != nil {
+			return fmt.Errorf("read counter schema migration %d: %w", version+1, err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE read_counter_schema SET version = ?`, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (store *SQLCounterStore) Load(uid string) (int32, error) {
+	var counter int32
+	err := store.db.QueryRow(`SELECT counter FROM read_counters WHERE uid = ?`, uid).Scan(&counter)
+	if err == sql.ErrNoRows {
+		return COUNTER_NONE, nil
+	}
+	if err != nil {
+		return COUNTER_NONE, err
+	}
+	return counter, nil
+}
+
+func (store *SQLCounterStore) CompareAndSwap(uid string, oldCounter int32, newCounter int32) (bool, error) {
+	now := time.Now().Unix()
+	var result sql.Result
+	var err error
+	if oldCounter == COUNTER_NONE {
+		result, err = store.db.Exec(`INSERT OR IGNORE INTO read_counters (uid, counter, updated_at) VALUES (?, ?, ?)`, uid, newCounter, now)
+	} else {
+		result, err = store.db.Exec(`UPDATE read_counters SET counter = ?, updated_at = ? WHERE uid = ? AND counter = ?`, newCounter, now, uid, oldCounter)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
@@ -0,0 +1,67 @@
+package decoder
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLCounterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.db")
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store, err := NewSQLCounterStore(db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testCounterStore(t, store)
+	db.Close()
+
+	// Reopening keeps the counters and does not re-run migrations
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	store, err = NewSQLCounterStore(db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if counter, _ := store.Load("0471862a506380"); counter != 0x7fffff {
+		t.Errorf("Wrong counter after reopening: %d", counter)
+	}
+	var version int
+	db.QueryRow(`SELECT version FROM read_counter_schema`).Scan(&version)
+	if version != len(sqlCounterStoreMigrations) {
+		t.Errorf("Wrong schema version: %d", version)
+	}
+}
+
+func TestSQLCounterStoreMigration(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "counters.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// A database created with only the first migration
+	db.Exec(`CREATE TABLE read_counter_schema (version INTEGER NOT NULL)`)
+	db.Exec(`INSERT INTO read_counter_schema (version) VALUES (1)`)
+	db.Exec(sqlCounterStoreMigrations[0])
+	db.Exec(`INSERT INTO read_counters (uid, counter) VALUES ('0471862a506380', 3)`)
+
+	store, err := NewSQLCounterStore(db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if counter, _ := store.Load("0471862a506380"); counter != 3 {
+		t.Errorf("Wrong counter after migrating: %d", counter)
+	}
+	if swapped, err := store.CompareAndSwap("0471862a506380", 3, 4); !swapped || err != nil {
+		t.Errorf("Could not update after migrating: %v", err)
+	}
+}
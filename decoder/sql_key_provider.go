@@ -0,0 +1,44 @@
+package decoder
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// SQLKeyProvider is a KeyProvider which looks up keys in a database.  Query
+// is given the UID (as lowercase hex) as its only parameter, and must select
+// the key, either as bytes or as a hex string, such as:
+//
+//	SELECT mac_key FROM tags WHERE uid = ?
+type SQLKeyProvider struct {
+	DB    *sql.DB
+	Query string
+}
+
+// NewSQLKeyProvider creates an SQLKeyProvider.
+func NewSQLKeyProvider(db *sql.DB, query string) *SQLKeyProvider {
+	return &SQLKeyProvider{
+		DB:    db,
+		Query: query,
+	}
+}
+
+func (provider *SQLKeyProvider) TagKey(uid []byte) ([]byte, error) {
+	var value []byte
+	err := provider.DB.QueryRow(provider.Query, hex.EncodeToString(uid)).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownUID, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(value) == 32 {
+		if key, err := hex.DecodeString(string(value)); err == nil {
+			return key, nil
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,55 @@
+package decoder
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLKeyProvider(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	blobKey, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	setup := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"CREATE TABLE tags (uid TEXT PRIMARY KEY, mac_key)", nil},
+		{"INSERT INTO tags VALUES (?, ?)", []interface{}{"0421272aaa6180", "0F0E0D0C0B0A09080706050403020100"}},
+		{"INSERT INTO tags VALUES (?, ?)", []interface{}{"04958caa5c5e80", blobKey}},
+	}
+	for _, statement := range setup {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	provider := NewSQLKeyProvider(db, "SELECT mac_key FROM tags WHERE uid = ?")
+	testcases := []struct {
+		uid string
+		key string
+	}{
+		{"0421272aaa6180", "0f0e0d0c0b0a09080706050403020100"},
+		{"04958caa5c5e80", "000102030405060708090a0b0c0d0e0f"},
+	}
+	for idx, testcase := range testcases {
+		uid, _ := hex.DecodeString(testcase.uid)
+		key, err := provider.TagKey(uid)
+		if err != nil || hex.EncodeToString(key) != testcase.key {
+			t.Errorf("Testcase %d: wrong key.  Expected %s, received %x (%v)", idx, testcase.key, key, err)
+		}
+	}
+
+	uid, _ := hex.DecodeString("049b112a2f7080")
+	if _, err := provider.TagKey(uid); !errors.Is(err, ErrUnknownUID) {
+		t.Errorf("Expected unknown UID error, received %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package decoder
+
+import (
+	"fmt"
+)
+
+// TamperState is the state of the tamper loop of an NTAG 424 DNA TT chip, as
+// mirrored (as an ASCII character) into the SUN message.
+type TamperState byte
+
+const (
+	// TAMPER_CLOSED means the tamper loop is (or has always been) closed.
+	TAMPER_CLOSED TamperState = 'C'
+	// TAMPER_OPEN means the tamper loop is (or has been) open.
+	TAMPER_OPEN TamperState = 'O'
+	// TAMPER_INVALID means the tamper loop state is unknown (e.g., tamper detection is not enabled).
+	TAMPER_INVALID TamperState = 'I'
+)
+
+// TamperStatus is the tamper status mirrored by NTAG 424 DNA TT chips.
+// Permanent is the first character, which stays open once the loop has been
+// seen open, and Current is the second, which is the state when tapped.
+// Authenticated tells whether the status was covered by a validated MAC; if
+// not, it could have been changed by anyone.
+//
+// The chip mirrors the status at the TTStatusOffset from its file settings.
+// Give the same offset as the SDMLayout's TTStatusOffset (or put {tt} in the
+// URL template); it is not read from FileSettings.
+type TamperStatus struct {
+	Permanent     TamperState
+	Current       TamperState
+	Authenticated bool
+}
+
+// ParseTamperStatus parses the 2-character mirrored tamper status (such as "CC").
+// The result is not Authenticated.
+func ParseTamperStatus(status string) (TamperStatus, error) {
+	if len(status) != 2 {
+		return TamperStatus{}, fmt.Errorf("%w: %q is not 2 characters", ErrInvalidTamperStatus, status)
+	}
+	result := TamperStatus{
+		Permanent: TamperState(status[0]),
+		Current:   TamperState(status[1]),
+	}
+	for _, state := range []TamperState{result.Permanent, result.Current} {
+		switch state {
+		case TAMPER_CLOSED, TAMPER_OPEN, TAMPER_INVALID:
+		default:
+			return TamperStatus{}, fmt.Errorf("%w: %q", ErrInvalidTamperStatus, status)
+		}
+	}
+	return result, nil
+}
+
+// parseMirroredTamperStatus parses a mirrored tamper status for a Result.
+func parseMirroredTamperStatus(status string, authenticated bool) (*TamperStatus, error) {
+	result, err := ParseTamperStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	result.Authenticated = authenticated
+	return &result, nil
+}
+
+// String gives the status as the chip mirrors it.
+func (status TamperStatus) String() string {
+	return string([]byte{byte(status.Permanent), byte(status.Current)})
+}
+
+// Opened tells whether the tamper loop is, or has ever been, open.
+func (status TamperStatus) Opened() bool {
+	return status.Permanent == TAMPER_OPEN || status.Current == TAMPER_OPEN
+}
+
+// SealBroken tells whether the message is authentic, but the chip's
+// authenticated tamper status shows that its seal has been broken.
+func (result Result) SealBroken() bool {
+	return result.Validated && result.TamperStatus != nil && result.TamperStatus.Authenticated && result.TamperStatus.Opened()
+}
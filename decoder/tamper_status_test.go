@@ -0,0 +1,123 @@
+package decoder
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseTamperStatus(t *testing.T) {
+	status, err := ParseTamperStatus("OC")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if status.Permanent != TAMPER_OPEN || status.Current != TAMPER_CLOSED || status.Authenticated || !status.Opened() || status.String() != "OC" {
+		t.Errorf("Wrong status: %+v", status)
+	}
+	if status, _ := ParseTamperStatus("II"); status.Opened() {
+		t.Errorf("Invalid status is open")
+	}
+
+	for _, invalid := range []string{"", "C", "CCC", "cc", "XC", "C0"} {
+		if _, err := ParseTamperStatus(invalid); !errors.Is(err, ErrInvalidTamperStatus) {
+			t.Errorf("Expected invalid tamper status error for %q, received %v", invalid, err)
+		}
+	}
+}
+
+func TestTamperStatus(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	url := "https://x.example/t?p=" + strings.Repeat("0", 32) + "&tt=00&m=" + strings.Repeat("0", 16) + "&u=00"
+	template, err := ParseURLTemplate("https://x.example/t?p={picc}&{macinput}tt={tt}&m={cmac}&u=00")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	covered := NewSDMLayout()
+	file := testVirtualTagFile(url, map[string]*int{
+		"p=":  &covered.PICCDataOffset,
+		"tt=": &covered.TTStatusOffset,
+		"m=":  &covered.SDMMACOffset,
+	})
+	covered.SDMMACInputOffset = covered.TTStatusOffset - 3
+
+	// The same file, but the status is mirrored after the MAC
+	uncovered := covered
+	uncovered.TTStatusOffset = strings.Index(string(file), "u=") + 2
+
+	testcases := []struct {
+		layout        SDMLayout
+		status        TamperStatus
+		authenticated bool
+		sealBroken    bool
+	}{
+		{covered, TamperStatus{Permanent: TAMPER_CLOSED, Current: TAMPER_CLOSED}, true, false},
+		{covered, TamperStatus{Permanent: TAMPER_OPEN, Current: TAMPER_CLOSED}, true, true},
+		{covered, TamperStatus{Permanent: TAMPER_OPEN, Current: TAMPER_OPEN}, true, true},
+		{covered, TamperStatus{Permanent: TAMPER_INVALID, Current: TAMPER_INVALID}, true, false},
+		{uncovered, TamperStatus{Permanent: TAMPER_OPEN, Current: TAMPER_OPEN}, false, false},
+	}
+	for idx, testcase := range testcases {
+		tag := VirtualTag{
+			Uid:          36136180498510340,
+			Keyset:       keyset,
+			Layout:       testcase.layout,
+			File:         file,
+			Rand:         rand.New(rand.NewSource(int64(idx))),
+			TamperStatus: testcase.status,
+		}
+		tapUrl, err := tag.Tap()
+		if err != nil {
+			t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+		}
+
+		results := []Result{}
+		result, err := keyset.VerifyLayoutURL(testcase.layout, tapUrl)
+		if err != nil {
+			t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+		}
+		results = append(results, result)
+		if testcase.layout == covered {
+			result, err = keyset.VerifyURL(template, tapUrl)
+			if err != nil {
+				t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+			}
+			results = append(results, result)
+		}
+
+		for _, result := range results {
+			if !result.Validated || result.TamperStatus == nil {
+				t.Fatalf("Testcase %d: not validated, or no tamper status", idx)
+			}
+			if result.TamperStatus.String() != testcase.status.String() || result.TamperStatus.Authenticated != testcase.authenticated {
+				t.Errorf("Testcase %d: wrong tamper status.  Expected %s (%t), received %s (%t)", idx, testcase.status, testcase.authenticated, result.TamperStatus, result.TamperStatus.Authenticated)
+			}
+			if result.SealBroken() != testcase.sealBroken {
+				t.Errorf("Testcase %d: seal broken should be %t", idx, testcase.sealBroken)
+			}
+		}
+
+		// Changing an authenticated status is detected
+		if testcase.authenticated {
+			tampered := strings.Replace(tapUrl, "tt="+testcase.status.String(), "tt=CC", 1)
+			if tampered == tapUrl {
+				continue
+			}
+			result, err := keyset.VerifyLayoutURL(testcase.layout, tampered)
+			if err != nil {
+				t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+			}
+			if result.Validated || result.TamperStatus.Authenticated {
+				t.Errorf("Testcase %d: tampered status was authenticated", idx)
+			}
+		}
+	}
+
+	// Garbage in the status is an error
+	tag := VirtualTag{Uid: 36136180498510340, Keyset: keyset, Layout: covered, File: file}
+	tapUrl, _ := tag.Tap()
+	if _, err := keyset.VerifyURL(template, strings.Replace(tapUrl, "tt=CC", "tt=XX", 1)); !errors.Is(err, ErrInvalidTamperStatus) {
+		t.Errorf("Expected invalid tamper status error, received %v", err)
+	}
+}
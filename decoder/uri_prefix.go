@@ -0,0 +1,60 @@
+package decoder
+
+import (
+	"strings"
+)
+
+// URIPrefixes are the abbreviations used by NDEF URI records, indexed by their
+// identifier code.  Code 0 means no abbreviation.
+var URIPrefixes = []string{
+	"",
+	"http://www.",
+	"https://www.",
+	"http://",
+	"https://",
+	"tel:",
+	"mailto:",
+	"ftp://anonymous:anonymous@",
+	"ftp://ftp.",
+	"ftps://",
+	"sftp://",
+	"smb://",
+	"nfs://",
+	"ftp://",
+	"dav://",
+	"news:",
+	"telnet://",
+	"imap:",
+	"rtsp://",
+	"urn:",
+	"pop:",
+	"sip:",
+	"sips:",
+	"tftp:",
+	"btspp://",
+	"btl2cap://",
+	"btgoep://",
+	"tcpobex://",
+	"irdaobex://",
+	"file://",
+	"urn:epc:id:",
+	"urn:epc:tag:",
+	"urn:epc:pat:",
+	"urn:epc:raw:",
+	"urn:epc:",
+	"urn:nfc:",
+}
+
+// AbbreviateURI finds the longest URI prefix abbreviation for the URI,
+// returning its identifier code and the rest of the URI.
+func AbbreviateURI(uri string) (code byte, rest string) {
+	longest := 0
+	for idx, prefix := range URIPrefixes {
+		if len(prefix) > longest && strings.HasPrefix(uri, prefix) {
+			code = byte(idx)
+			longest = len(prefix)
+		}
+	}
+
+	return code, uri[longest:]
+}
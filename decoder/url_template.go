@@ -0,0 +1,191 @@
+package decoder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Placeholders which can be used in a URLTemplate.
+const (
+	// PLACEHOLDER_PICC_DATA is the (encrypted) PICCData.
+	PLACEHOLDER_PICC_DATA = "{picc}"
+	// PLACEHOLDER_ENC_FILE_DATA is the encrypted file data.
+	PLACEHOLDER_ENC_FILE_DATA = "{enc}"
+	// PLACEHOLDER_MAC is the MAC.
+	PLACEHOLDER_MAC = "{cmac}"
+	// PLACEHOLDER_UID is the plain UID mirror (used when the PICCData is not encrypted).
+	PLACEHOLDER_UID = "{uid}"
+	// PLACEHOLDER_READ_COUNTER is the plain read counter mirror (used when the PICCData is not encrypted).
+	PLACEHOLDER_READ_COUNTER = "{ctr}"
+	// PLACEHOLDER_TT_STATUS is the tamper status of NTAG 424 DNA TT chips.
+	PLACEHOLDER_TT_STATUS = "{tt}"
+	// PLACEHOLDER_MAC_INPUT marks where SDMMACInputOffset points.  It matches nothing.
+	// If it is not given, the MAC is over an empty string.
+	PLACEHOLDER_MAC_INPUT = "{macinput}"
+)
+
+var placeholderPatterns = map[string]string{
+	PLACEHOLDER_PICC_DATA:     "[0-9A-Fa-f]+",
+	PLACEHOLDER_ENC_FILE_DATA: "[0-9A-Fa-f]+",
+	PLACEHOLDER_MAC:           "[0-9A-Fa-f]{16}",
+	PLACEHOLDER_UID:           "[0-9A-Fa-f]{14}",
+	PLACEHOLDER_READ_COUNTER:  "[0-9A-Fa-f]{6}",
+	PLACEHOLDER_TT_STATUS:     "[A-Za-z]{2}",
+	PLACEHOLDER_MAC_INPUT:     "",
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{[a-z]*\}`)
+
+// URLTemplate describes where a chip mirrors the parts of a SUN message into a URL,
+// such as "https://x.example/t?p={picc}&m={cmac}" or "https://x.example/t/{picc}/{cmac}".
+// The scheme and host are matched case-insensitively, the rest of the URL exactly.
+type URLTemplate struct {
+	Template     string
+	pattern      *regexp.Regexp
+	placeholders []string
+}
+
+// URLComponents are the hex strings matched by a URLTemplate.
+// Components which are not in the template are empty.
+type URLComponents struct {
+	PICCData    string
+	EncFileData string
+	MAC         string
+	UID         string
+	ReadCounter string
+	TTStatus    string
+	// MACInputOffset and MACOffset are the offsets into the URL of the MACed data and the MAC.
+	MACInputOffset int
+	MACOffset      int
+	// TTStatusOffset is the offset into the URL of the tamper status, if any.
+	TTStatusOffset int
+}
+
+// ParseURLTemplate parses a URL template.  The template must include a MAC,
+// and either the PICCData or the plain UID and read counter.
+func ParseURLTemplate(template string) (*URLTemplate, error) {
+	hostEnd := len(template)
+	if schemeIdx := strings.Index(template, "://"); schemeIdx >= 0 {
+		if pathIdx := strings.IndexAny(template[schemeIdx+3:], "/?#"); pathIdx >= 0 {
+			hostEnd = schemeIdx + 3 + pathIdx
+		}
+	} else {
+		hostEnd = 0
+	}
+
+	result := &URLTemplate{
+		Template: template,
+	}
+	seen := map[string]bool{}
+	pattern := "^"
+	previous := 0
+	for _, loc := range placeholderRegexp.FindAllStringIndex(template, -1) {
+		placeholder := template[loc[0]:loc[1]]
+		placeholderPattern, ok := placeholderPatterns[placeholder]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown placeholder %s", ErrInvalidTemplate, placeholder)
+		}
+		if seen[placeholder] {
+			return nil, fmt.Errorf("%w: duplicate placeholder %s", ErrInvalidTemplate, placeholder)
+		}
+		if loc[0] < hostEnd {
+			return nil, fmt.Errorf("%w: placeholder %s in host", ErrInvalidTemplate, placeholder)
+		}
+		seen[placeholder] = true
+		result.placeholders = append(result.placeholders, placeholder)
+
+		pattern += quoteTemplateLiteral(template[previous:loc[0]], previous, hostEnd)
+		pattern += "(" + placeholderPattern + ")"
+		previous = loc[1]
+	}
+	pattern += quoteTemplateLiteral(template[previous:], previous, hostEnd) + "$"
+
+	if !seen[PLACEHOLDER_MAC] {
+		return nil, fmt.Errorf("%w: no %s placeholder", ErrInvalidTemplate, PLACEHOLDER_MAC)
+	}
+	if seen[PLACEHOLDER_PICC_DATA] == (seen[PLACEHOLDER_UID] || seen[PLACEHOLDER_READ_COUNTER]) {
+		return nil, fmt.Errorf("%w: need either %s or %s and %s", ErrInvalidTemplate, PLACEHOLDER_PICC_DATA, PLACEHOLDER_UID, PLACEHOLDER_READ_COUNTER)
+	}
+	if seen[PLACEHOLDER_UID] != seen[PLACEHOLDER_READ_COUNTER] {
+		return nil, fmt.Errorf("%w: need both %s and %s", ErrInvalidTemplate, PLACEHOLDER_UID, PLACEHOLDER_READ_COUNTER)
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	result.pattern = compiled
+
+	return result, nil
+}
+
+// quoteTemplateLiteral quotes the static text of a template which starts at offset,
+// making any part of it before hostEnd case-insensitive.
+func quoteTemplateLiteral(literal string, offset int, hostEnd int) string {
+	if offset >= hostEnd {
+		return regexp.QuoteMeta(literal)
+	}
+	split := hostEnd - offset
+	if split > len(literal) {
+		split = len(literal)
+	}
+	return "(?i:" + regexp.QuoteMeta(literal[0:split]) + ")" + regexp.QuoteMeta(literal[split:])
+}
+
+// Match extracts the components from a URL, returning ErrTemplateMismatch if the
+// static portions of the URL do not match the template.
+func (template *URLTemplate) Match(url string) (URLComponents, error) {
+	if template.pattern == nil {
+		return URLComponents{}, fmt.Errorf("%w: template was not created by ParseURLTemplate", ErrInvalidTemplate)
+	}
+	locs := template.pattern.FindStringSubmatchIndex(url)
+	if locs == nil {
+		return URLComponents{}, ErrTemplateMismatch
+	}
+
+	components := URLComponents{
+		MACInputOffset: -1,
+	}
+	for idx, placeholder := range template.placeholders {
+		start := locs[2*idx+2]
+		value := url[start:locs[2*idx+3]]
+		switch placeholder {
+		case PLACEHOLDER_PICC_DATA:
+			components.PICCData = value
+		case PLACEHOLDER_ENC_FILE_DATA:
+			components.EncFileData = value
+		case PLACEHOLDER_MAC:
+			components.MAC = value
+			components.MACOffset = start
+		case PLACEHOLDER_UID:
+			components.UID = value
+		case PLACEHOLDER_READ_COUNTER:
+			components.ReadCounter = value
+		case PLACEHOLDER_TT_STATUS:
+			components.TTStatus = value
+			components.TTStatusOffset = start
+		case PLACEHOLDER_MAC_INPUT:
+			components.MACInputOffset = start
+		}
+	}
+	if components.MACInputOffset == -1 {
+		components.MACInputOffset = components.MACOffset
+	}
+	if components.MACInputOffset > components.MACOffset {
+		return URLComponents{}, fmt.Errorf("%w: %s is after %s", ErrInvalidTemplate, PLACEHOLDER_MAC_INPUT, PLACEHOLDER_MAC)
+	}
+
+	return components, nil
+}
+
+// plainMirror tells whether the template has the plain UID and read counter
+// mirrors (rather than the PICCData).
+func (template *URLTemplate) plainMirror() bool {
+	for _, placeholder := range template.placeholders {
+		if placeholder == PLACEHOLDER_UID || placeholder == PLACEHOLDER_READ_COUNTER {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package decoder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestURLTemplate(t *testing.T) {
+	template, err := ParseURLTemplate("https://sdm.example.com/tag?picc_data={picc}&enc={macinput}{enc}&cmac={cmac}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	url := "https://SDM.Example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ecc1e7f6c6c73bf6"
+	components, err := template.Match(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if components.PICCData != "FD91EC264309878BE6345CBE53BADF40" {
+		t.Errorf("Bad PICCData: %s", components.PICCData)
+	}
+	if components.EncFileData != "CEE9A53E3E463EF1F459635736738962" {
+		t.Errorf("Bad file data: %s", components.EncFileData)
+	}
+	if components.MAC != "ecc1e7f6c6c73bf6" {
+		t.Errorf("Bad MAC: %s", components.MAC)
+	}
+	if url[components.MACInputOffset:components.MACOffset] != "CEE9A53E3E463EF1F459635736738962&cmac=" {
+		t.Errorf("Bad MAC input: %s", url[components.MACInputOffset:components.MACOffset])
+	}
+
+	mismatches := []string{
+		"https://sdm.example.com/Tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6",
+		"https://sdm.example.org/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6",
+		"https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6&x=1",
+		"https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF4Z&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6",
+		"https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73B",
+	}
+	for _, mismatch := range mismatches {
+		if _, err := template.Match(mismatch); !errors.Is(err, ErrTemplateMismatch) {
+			t.Errorf("Expected mismatch for %s, received %v", mismatch, err)
+		}
+	}
+}
+
+func TestURLTemplatePathSegments(t *testing.T) {
+	template, err := ParseURLTemplate("https://webhooks.s-digital.co/dev/dna/{picc}/{cmac}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	piccKey := Key{
+		KeyData: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x11},
+	}
+	macKey := Key{
+		KeyData: []byte{0x04, 0xa4, 0x33, 0x2a, 0xaa, 0x61, 0x80, 0x00, 0x04, 0xa4, 0x33, 0x2a, 0xaa, 0x61, 0x80, 0x00},
+	}
+	keyset := Keyset{
+		Mode:              AES,
+		Keys:              []Key{piccKey, macKey},
+		MetaReadKey:       0,
+		FileReadKey:       KEY_NONE,
+		AuthenticationKey: 1,
+	}
+	result, err := keyset.VerifyURL(template, "https://webhooks.s-digital.co/dev/dna/3983AEF66052A9C9FBE82821F8E23ECA/4DF5A6877EA54754")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if result.Meta.ReadCounter != 33 {
+		t.Errorf("Wrong read counter: %d", result.Meta.ReadCounter)
+	}
+
+	result, err = keyset.VerifyURL(template, "https://webhooks.s-digital.co/dev/dna/3983AEF66052A9C9FBE82821F8E23ECA/4DF5A6877EA54755")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Validated {
+		t.Errorf("Validated, but should not have been")
+	}
+
+	if _, err := keyset.VerifyURL(template, "https://webhooks.s-digital.co/dev/dna/3983AEF66052A9C9FBE82821F8E23ECA"); !errors.Is(err, ErrTemplateMismatch) {
+		t.Errorf("Expected mismatch, received %v", err)
+	}
+}
+
+func TestVerifyURLWithFileData(t *testing.T) {
+	template, _ := ParseURLTemplate("https://sdm.example.com/tag?picc_data={picc}&enc={macinput}{enc}&cmac={cmac}")
+	keyset := Keyset{
+		Mode: LRP,
+		Keys: []Key{
+			Key{
+				KeyData: make([]byte, 16),
+			},
+		},
+	}
+	result, err := keyset.VerifyURL(template, "https://sdm.example.com/tag?picc_data=07D9CA2545881D4BFDD920BE1603268C0714420DD893A497&enc=D6E921C47DB4C17C56F979F81559BB83&cmac=F9481AC7D855BDB6")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if string(result.FileData) != "NTXXb7dz3PsYYBlU" {
+		t.Errorf("Bad file data: %s", result.FileData)
+	}
+}
+
+func TestParseURLTemplateErrors(t *testing.T) {
+	templates := []string{
+		"https://x.example/t?p={picc}",
+		"https://x.example/t?m={cmac}",
+		"https://x.example/t?p={picc}&p2={picc}&m={cmac}",
+		"https://x.example/t?p={picc}&u={uid}&m={cmac}",
+		"https://x.example/t?u={uid}&m={cmac}",
+		"https://x.example/t?p={bogus}&m={cmac}",
+		"https://{picc}.example/t?m={cmac}",
+	}
+	for _, template := range templates {
+		if _, err := ParseURLTemplate(template); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("Expected invalid template for %s, received %v", template, err)
+		}
+	}
+}
+
+func TestVerifyURLPlainMirror(t *testing.T) {
+	template, _ := ParseURLTemplate("https://x.example/t?u={uid}&c={ctr}&m={cmac}")
+	result, err := testVerifyKeyset(AES).VerifyURL(template, "https://x.example/t?u=0471862A506380&c=000003&m=637618472FE7D110")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if result.Meta.UidHex() != "0471862a506380" || result.Meta.ReadCounter != 3 {
+		t.Errorf("Wrong meta: %s / %d", result.Meta.UidHex(), result.Meta.ReadCounter)
+	}
+}
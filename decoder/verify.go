@@ -0,0 +1,201 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// Result is the outcome of verifying a SUN message.
+// Validated is only true if the MAC matched.
+// FileData is the decrypted file data, if any was mirrored.
+// MAC is the MAC as received, whether or not it matched.
+// TamperStatus is the tamper status of NTAG 424 DNA TT chips, if it was mirrored.
+// Unauthenticated is set for messages which have no MAC at all (such as NTAG 21x
+// mirrors), which can never be Validated.
+type Result struct {
+	Meta            Meta
+	Validated       bool
+	FileData        []byte
+	MAC             []byte
+	TamperStatus    *TamperStatus
+	Unauthenticated bool
+}
+
+// Verify is like DecodeEncryptedMetaStringWithAuthenticator, but returns an error
+// if the message could not be decoded at all.  A message which decodes but has
+// the wrong MAC is not an error, but returns a Result which is not Validated.
+func (keyset *Keyset) Verify(dataStr string, authenticatorStr string) (Result, error) {
+	meta, err := keyset.forPICCData(dataStr).DecodeMetaString(dataStr)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return verifyMeta(meta, authenticatorStr)
+}
+
+// verifyMeta finishes Verify with PICCData which has already been decoded.
+func verifyMeta(meta Meta, authenticatorStr string) (Result, error) {
+	authenticator, err := hex.DecodeString(authenticatorStr)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: MAC: %v", ErrBadHex, err)
+	}
+	if len(authenticator) != 8 {
+		return Result{}, fmt.Errorf("%w: MAC is %d bytes, expected 8", ErrWrongLength, len(authenticator))
+	}
+
+	code, err := meta.validationCode([]byte{})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Meta:      meta,
+		Validated: bytes.Equal(code, authenticator),
+		MAC:       authenticator,
+	}, nil
+}
+
+// VerifyMirror verifies a message given the full mirrored data (the NDEF
+// file contents or the URL) and the offsets configured on the chip.
+// The PICCData is read from piccDataOffset, and the MAC is checked as
+// described in Meta#ValidateMirroredMAC.  All offsets must be relative to
+// the start of mirror.
+func (keyset *Keyset) VerifyMirror(mirror []byte, piccDataOffset int, macInputOffset int, macOffset int) (Result, error) {
+	piccDataLength := keyset.PICCDataLength()
+	if piccDataOffset < 0 || piccDataOffset > len(mirror)-piccDataLength {
+		return Result{}, fmt.Errorf("%w: PICCData at offset %d does not fit in %d bytes", ErrWrongLength, piccDataOffset, len(mirror))
+	}
+	meta, err := keyset.DecodeMetaString(string(mirror[piccDataOffset:(piccDataOffset + piccDataLength)]))
+	if err != nil {
+		return Result{}, err
+	}
+
+	validated, err := meta.ValidateMirroredMAC(mirror, macInputOffset, macOffset)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Meta:      meta,
+		Validated: validated,
+		MAC:       mirroredMAC(mirror, macOffset),
+	}, nil
+}
+
+// PICCDataLength gives the number of ASCII characters the chip mirrors for the PICCData
+// (or the UID and read counter, if the PICCData is not encrypted).
+func (keyset *Keyset) PICCDataLength() int {
+	if keyset.MetaReadKey == KEY_NONE {
+		return 20
+	}
+	if keyset.Mode == LRP {
+		return 48
+	}
+	return 32
+}
+
+// ValidateMirroredMAC checks the MAC mirrored (as 16 hex characters) at
+// macOffset in the mirrored data.  The MAC is computed over exactly the
+// bytes the chip MACs, which are the ASCII bytes from macInputOffset up to
+// (but not including) macOffset.  If macInputOffset equals macOffset, this
+// is the MAC of an empty string.
+func (meta *Meta) ValidateMirroredMAC(mirror []byte, macInputOffset int, macOffset int) (bool, error) {
+	if macInputOffset < 0 || macInputOffset > macOffset {
+		return false, fmt.Errorf("%w: SDMMACInputOffset %d is after SDMMACOffset %d", ErrWrongLength, macInputOffset, macOffset)
+	}
+	if macOffset > len(mirror)-16 {
+		return false, fmt.Errorf("%w: MAC at offset %d does not fit in %d bytes", ErrWrongLength, macOffset, len(mirror))
+	}
+
+	authenticator, err := hex.DecodeString(string(mirror[macOffset:(macOffset + 16)]))
+	if err != nil {
+		return false, fmt.Errorf("%w: MAC: %v", ErrBadHex, err)
+	}
+
+	code, err := meta.validationCode(mirror[macInputOffset:macOffset])
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(code, authenticator), nil
+}
+
+// mirroredMAC gives the MAC mirrored at macOffset.  It must already have been
+// checked by ValidateMirroredMAC.
+func mirroredMAC(mirror []byte, macOffset int) []byte {
+	mac, _ := hex.DecodeString(string(mirror[macOffset:(macOffset + 16)]))
+	return mac
+}
+
+// VerifyURL verifies a full tap URL, using the template to find the parts of the
+// SUN message.  The MAC is checked over the part of the URL from the template's
+// {macinput} placeholder up to the MAC.  If the template has encrypted file data,
+// it is decrypted into the Result's FileData, and if it has a tamper status, it is
+// given in the Result's TamperStatus.
+func (keyset *Keyset) VerifyURL(template *URLTemplate, url string) (Result, error) {
+	components, err := template.Match(url)
+	if err != nil {
+		return Result{}, err
+	}
+
+	dataStr := components.PICCData
+	if dataStr == "" {
+		if keyset.RandomID {
+			return Result{}, fmt.Errorf("%w: template has plain UID and read counter mirrors", ErrRandomID)
+		}
+		dataStr = components.UID + components.ReadCounter
+	}
+	meta, err := keyset.forPICCData(dataStr).DecodeMetaString(dataStr)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return keyset.verifyURLMeta(components, url, meta)
+}
+
+// verifyURLMeta finishes VerifyURL with PICCData which has already been decoded.
+func (keyset *Keyset) verifyURLMeta(components URLComponents, url string, meta Meta) (Result, error) {
+	validated, err := meta.ValidateMirroredMAC([]byte(url), components.MACInputOffset, components.MACOffset)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Meta:      meta,
+		Validated: validated,
+		MAC:       mirroredMAC([]byte(url), components.MACOffset),
+	}
+
+	if components.TTStatus != "" {
+		authenticated := components.TTStatusOffset >= components.MACInputOffset && components.TTStatusOffset+2 <= components.MACOffset
+		result.TamperStatus, err = parseMirroredTamperStatus(components.TTStatus, result.Validated && authenticated)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	if components.EncFileData != "" {
+		encFileData, err := hex.DecodeString(components.EncFileData)
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: file data: %v", ErrBadHex, err)
+		}
+		result.FileData, err = keyset.DecryptFileData(meta, encFileData)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// forPICCData gives the keyset to use for decoding the PICCData.  If the data is
+// only as long as an unencrypted UID and read counter, encryption is turned off
+// (unless the tags use Random ID, which can't mirror them in plain).
+func (keyset *Keyset) forPICCData(dataStr string) *Keyset {
+	if len(dataStr) == 20 && keyset.MetaReadKey != KEY_NONE && !keyset.RandomID {
+		tmpKeyset := *keyset
+		tmpKeyset.MetaReadKey = KEY_NONE
+		return &tmpKeyset
+	}
+	return keyset
+}
@@ -0,0 +1,232 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func testVerifyKeyset(mode EncryptionMode) *Keyset {
+	e1bytes, _ := hex.DecodeString("e6cbb56d350c25eda052b27f81b1c884")
+	a1bytes, _ := hex.DecodeString("07f23a4c407485ea3122ff242f763e77")
+	app, _ := hex.DecodeString("3042f562696b65646e61")
+	return &Keyset{
+		Mode: mode,
+		Keys: []Key{
+			Key{
+				KeyData: e1bytes,
+			},
+			Key{
+				KeyData:     a1bytes,
+				Diversified: true,
+				Application: app,
+			},
+		},
+		MetaReadKey:       0,
+		FileReadKey:       0,
+		AuthenticationKey: 1,
+	}
+}
+
+func TestVerify(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	result, err := keyset.Verify("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("Not validated, but should have been")
+	}
+	if result.Meta.UidHex() != "0421272aaa6180" || result.Meta.ReadCounter != 2 {
+		t.Errorf("Wrong meta: %s / %d", result.Meta.UidHex(), result.Meta.ReadCounter)
+	}
+
+	result, err = keyset.Verify("0471862A506380000003", "637618472FE7D111")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Validated {
+		t.Errorf("Validated, but should not have been")
+	}
+
+	lrpKeyset := testVerifyKeyset(LRP)
+	result, err = lrpKeyset.Verify("9A07B1067A4B33687962AC328A34DD396510F12C4B066FE3", "AA5D0ADA7ED558DC")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.Validated {
+		t.Errorf("LRP not validated, but should have been")
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	unknown := testVerifyKeyset(UNKNOWN)
+	missing := testVerifyKeyset(AES)
+	missing.AuthenticationKey = 4
+	testcases := []struct {
+		keyset *Keyset
+		data   string
+		mac    string
+		err    error
+	}{
+		{testVerifyKeyset(AES), "CBF5374BC4874E7AE53961E6533DDC5", "C4B7E3310EFC2FA3", ErrBadHex},
+		{testVerifyKeyset(AES), "CBF5374BC4874E7AE53961E6533DDC5F", "XX", ErrBadHex},
+		{testVerifyKeyset(AES), "CBF5374BC4874E7AE53961E6533DDC", "C4B7E3310EFC2FA3", ErrWrongLength},
+		{testVerifyKeyset(AES), "CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2F", ErrWrongLength},
+		{testVerifyKeyset(AES), "9A07B1067A4B33687962AC328A34DD396510F12C4B066FE3", "AA5D0ADA7ED558DC", ErrWrongLength},
+		{testVerifyKeyset(LRP), "", "AA5D0ADA7ED558DC", ErrWrongLength},
+		{unknown, "CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3", ErrUnknownMode},
+		{missing, "CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3", ErrMissingKey},
+		// Decrypts to a tag with RFU bits set
+		{testVerifyKeyset(AES), "00000000000000000000000000000000", "C4B7E3310EFC2FA3", ErrInvalidPICCDataTag},
+	}
+	for idx, testcase := range testcases {
+		_, err := testcase.keyset.Verify(testcase.data, testcase.mac)
+		if !errors.Is(err, testcase.err) {
+			t.Errorf("Testcase %d: expected %v, received %v", idx, testcase.err, err)
+		}
+	}
+}
+
+func TestDecryptFileDataErrors(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	meta, _ := keyset.DecodeMetaString("CBF5374BC4874E7AE53961E6533DDC5F")
+	if _, err := keyset.DecryptFileData(meta, make([]byte, 15)); !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expected wrong length error, received %v", err)
+	}
+	keyset.FileReadKey = 7
+	if _, err := keyset.DecryptFileData(meta, make([]byte, 16)); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("Expected missing key error, received %v", err)
+	}
+}
+
+func TestParsePICCDataTag(t *testing.T) {
+	testcases := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{0xc7, 0x04, 0xde, 0x5f, 0x1e, 0xac, 0xc0, 0x40, 0x3d, 0x00, 0x00}, nil},
+		{[]byte{0x87, 0x04, 0xde, 0x5f, 0x1e, 0xac, 0xc0, 0x40}, nil},
+		{[]byte{0x40, 0x3d, 0x00, 0x00}, nil},
+		// Counter only, but with a UID length
+		{[]byte{0x47, 0x3d, 0x00, 0x00}, ErrInvalidPICCDataTag},
+		{[]byte{0x41, 0x3d, 0x00, 0x00}, ErrInvalidPICCDataTag},
+		{[]byte{0xc4, 0x04, 0xde, 0x5f, 0x1e, 0xac, 0xc0, 0x40, 0x3d, 0x00, 0x00}, ErrInvalidPICCDataTag},
+		{[]byte{0x00}, ErrInvalidPICCDataTag},
+	}
+	for idx, testcase := range testcases {
+		_, err := ParsePICCData(testcase.data)
+		if !errors.Is(err, testcase.err) {
+			t.Errorf("Testcase %d: expected %v, received %v", idx, testcase.err, err)
+		}
+	}
+}
+
+func FuzzDecodeMeta(f *testing.F) {
+	f.Add([]byte{}, uint8(AES))
+	f.Add(make([]byte, 10), uint8(AES))
+	f.Add(make([]byte, 16), uint8(AES))
+	f.Add(make([]byte, 24), uint8(LRP))
+	f.Fuzz(func(t *testing.T, data []byte, mode uint8) {
+		for _, keyset := range []*Keyset{testVerifyKeyset(EncryptionMode(mode)), testVerifyKeyset(EncryptionMode(mode % 3))} {
+			meta, err := keyset.DecodeMeta(data)
+			if err != nil {
+				continue
+			}
+			keyset.DecryptFileData(meta, data)
+			meta.validationCode(data)
+		}
+	})
+}
+
+func FuzzVerify(f *testing.F) {
+	f.Add("CBF5374BC4874E7AE53961E6533DDC5F", "C4B7E3310EFC2FA3", uint8(AES))
+	f.Add("0471862A506380000003", "637618472FE7D110", uint8(AES))
+	f.Add("9A07B1067A4B33687962AC328A34DD396510F12C4B066FE3", "AA5D0ADA7ED558DC", uint8(LRP))
+	f.Fuzz(func(t *testing.T, data string, mac string, mode uint8) {
+		testVerifyKeyset(EncryptionMode(mode%3)).Verify(data, mac)
+	})
+}
+
+func FuzzParsePICCData(f *testing.F) {
+	f.Add([]byte{0xc7, 0x04, 0xde, 0x5f, 0x1e, 0xac, 0xc0, 0x40, 0x3d, 0x00, 0x00})
+	f.Add([]byte{0x87})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ParsePICCData(data)
+	})
+}
+
+func TestVerifyMirror(t *testing.T) {
+	zeroKeyBinary, _ := hex.DecodeString(zeroKey)
+	testcases := []struct {
+		mode EncryptionMode
+		url  string
+	}{
+		{AES, "https://sdm.example.com/tag?picc_data=FD91EC264309878BE6345CBE53BADF40&enc=CEE9A53E3E463EF1F459635736738962&cmac=ECC1E7F6C6C73BF6"},
+		{LRP, "https://sdm.example.com/tag?picc_data=07D9CA2545881D4BFDD920BE1603268C0714420DD893A497&enc=D6E921C47DB4C17C56F979F81559BB83&cmac=F9481AC7D855BDB6"},
+	}
+	for idx, testcase := range testcases {
+		keyset := Keyset{
+			Mode: testcase.mode,
+			Keys: []Key{
+				Key{
+					KeyData: zeroKeyBinary,
+				},
+			},
+			MetaReadKey:       0,
+			FileReadKey:       0,
+			AuthenticationKey: 0,
+		}
+		piccDataOffset := strings.Index(testcase.url, "picc_data=") + 10
+		macInputOffset := strings.Index(testcase.url, "enc=") + 4
+		macOffset := strings.Index(testcase.url, "cmac=") + 5
+
+		result, err := keyset.VerifyMirror([]byte(testcase.url), piccDataOffset, macInputOffset, macOffset)
+		if err != nil {
+			t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+		}
+		if !result.Validated {
+			t.Errorf("Testcase %d: not validated, but should have been", idx)
+		}
+		if mac := strings.ToUpper(hex.EncodeToString(result.MAC)); mac != testcase.url[macOffset:] {
+			t.Errorf("Testcase %d: wrong MAC.  Expected %s, received %s", idx, testcase.url[macOffset:], mac)
+		}
+
+		// Tampering with the MACed file data should be detected
+		tampered := []byte(testcase.url)
+		tampered[macInputOffset] = '0'
+		result, err = keyset.VerifyMirror(tampered, piccDataOffset, macInputOffset, macOffset)
+		if err != nil {
+			t.Fatalf("Testcase %d: unexpected error: %v", idx, err)
+		}
+		if result.Validated {
+			t.Errorf("Testcase %d: validated, but should not have been", idx)
+		}
+
+		// So should tampering with the static text inside the MACed range
+		tampered = []byte(testcase.url)
+		tampered[macOffset-2] = 'M'
+		result, _ = keyset.VerifyMirror(tampered, piccDataOffset, macInputOffset, macOffset)
+		if result.Validated {
+			t.Errorf("Testcase %d: validated, but should not have been", idx)
+		}
+
+		if _, err := keyset.VerifyMirror([]byte(testcase.url), piccDataOffset, macOffset+1, macOffset); !errors.Is(err, ErrWrongLength) {
+			t.Errorf("Testcase %d: expected wrong length error, received %v", idx, err)
+		}
+		if _, err := keyset.VerifyMirror([]byte(testcase.url), piccDataOffset, macInputOffset, macOffset+1); !errors.Is(err, ErrWrongLength) {
+			t.Errorf("Testcase %d: expected wrong length error, received %v", idx, err)
+		}
+	}
+}
+
+func FuzzVerifyMirror(f *testing.F) {
+	f.Add([]byte("p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3"), 2, 37, 37)
+	f.Add([]byte("p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3"), 2, 37, math.MaxInt-8)
+	f.Add([]byte("p=CBF5374BC4874E7AE53961E6533DDC5F&m=C4B7E3310EFC2FA3"), math.MaxInt-8, 37, 37)
+	f.Fuzz(func(t *testing.T, mirror []byte, piccDataOffset int, macInputOffset int, macOffset int) {
+		testVerifyKeyset(AES).VerifyMirror(mirror, piccDataOffset, macInputOffset, macOffset)
+	})
+}
@@ -0,0 +1,176 @@
+package decoder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MAX_READ_COUNTER is the highest value of the chip's 24-bit read counter.
+const MAX_READ_COUNTER = 0xffffff
+
+// VirtualTag simulates an NTAG 424 DNA with SDM enabled, for generating
+// valid taps for testing without physical tags.
+// File is the contents of the NDEF file (including NLEN), and Layout gives
+// the SDM offsets into it.  The file data which is encrypted is read from
+// the file at SDMENCOffset (like the chip), unless FileData is set.
+// Rand is used for the random parts of the PICCData, and defaults to crypto/rand.
+// TamperStatus is mirrored if the layout has a TTStatusOffset (both states are
+// closed if they are not set).
+// SDMOptions chooses whether the PICCData has the UID (SDM_OPTION_UID) and the
+// read counter (SDM_OPTION_READ_CTR), like the chip's file settings; if neither
+// is set, it has both.  Plain mirrors are chosen by the layout's offsets.
+type VirtualTag struct {
+	Uid          int64
+	ReadCounter  int32
+	Keyset       *Keyset
+	Layout       SDMLayout
+	File         []byte
+	FileData     []byte
+	Rand         io.Reader
+	TamperStatus TamperStatus
+	SDMOptions   byte
+}
+
+// Tap increments the read counter and gives the URL the chip would produce.
+func (tag *VirtualTag) Tap() (string, error) {
+	file, err := tag.TapFile()
+	if err != nil {
+		return "", err
+	}
+	records, err := ParseNDEFFile(file)
+	if err != nil {
+		return "", err
+	}
+	return FindSUNURI(records)
+}
+
+// TapFile increments the read counter and gives the NDEF file contents
+// (with all of the fields mirrored) the chip would produce.  The read counter
+// is only incremented if the tap succeeds, and once it reaches
+// MAX_READ_COUNTER (which the 24-bit counter can't go past), taps fail with
+// ErrReadCounterExhausted.
+func (tag *VirtualTag) TapFile() ([]byte, error) {
+	random := tag.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	keyset := tag.Keyset
+	layout := tag.Layout
+
+	if tag.ReadCounter >= MAX_READ_COUNTER {
+		return nil, ErrReadCounterExhausted
+	}
+	readCounter := tag.ReadCounter + 1
+	meta := NewMeta(tag.Uid, readCounter, keyset)
+	if layout.PICCDataOffset != OFFSET_NONE {
+		options := tag.SDMOptions & (SDM_OPTION_UID | SDM_OPTION_READ_CTR)
+		if options != 0 {
+			meta.HasUid = (options & SDM_OPTION_UID) != 0
+			meta.HasReadCounter = (options & SDM_OPTION_READ_CTR) != 0
+		}
+	} else {
+		meta.HasUid = layout.UIDOffset != OFFSET_NONE
+		meta.HasReadCounter = layout.SDMReadCtrOffset != OFFSET_NONE
+	}
+	file := append([]byte{}, tag.File...)
+
+	if layout.UIDOffset != OFFSET_NONE {
+		plain := EncodeUnencryptedBytes(meta)
+		if err := mirrorField(file, "UID", layout.UIDOffset, plain[0:7]); err != nil {
+			return nil, err
+		}
+	}
+	if layout.SDMReadCtrOffset != OFFSET_NONE {
+		plain := EncodeUnencryptedBytes(meta)
+		if err := mirrorField(file, "SDMReadCtr", layout.SDMReadCtrOffset, plain[7:10]); err != nil {
+			return nil, err
+		}
+	}
+
+	if layout.PICCDataOffset != OFFSET_NONE {
+		keyBytes, err := keyset.keyBytes(keyset.MetaReadKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		var piccData []byte
+		switch keyset.Mode {
+		case AES:
+			piccData, err = EncryptMetaAES(keyBytes, meta, random)
+		case LRP:
+			piccData, err = EncryptMetaLRP(keyBytes, meta, random)
+		default:
+			err = ErrUnknownMode
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := mirrorField(file, "PICCData", layout.PICCDataOffset, piccData); err != nil {
+			return nil, err
+		}
+	}
+
+	if layout.SDMENCOffset != OFFSET_NONE {
+		fileData := tag.FileData
+		if fileData == nil {
+			if layout.SDMENCOffset < 0 || layout.SDMENCOffset > len(tag.File)-layout.SDMENCLength/2 {
+				return nil, fmt.Errorf("%w: SDMENCFileData does not fit in the file", ErrWrongLength)
+			}
+			fileData = tag.File[layout.SDMENCOffset:(layout.SDMENCOffset + layout.SDMENCLength/2)]
+		}
+		if len(fileData)*2 != layout.SDMENCLength || len(fileData)%16 != 0 {
+			return nil, fmt.Errorf("%w: file data is %d bytes, but SDMENCLength is %d", ErrWrongLength, len(fileData), layout.SDMENCLength)
+		}
+		if err := meta.checkFileDataMirrors(); err != nil {
+			return nil, err
+		}
+		if _, err := keyset.keyBytes(keyset.FileReadKey, meta.mirroredUidBytes()); err != nil {
+			return nil, err
+		}
+		if err := mirrorField(file, "SDMENCFileData", layout.SDMENCOffset, meta.EncryptFileData(fileData)); err != nil {
+			return nil, err
+		}
+	}
+
+	if layout.TTStatusOffset != OFFSET_NONE {
+		status := tag.TamperStatus
+		if status.Permanent == 0 {
+			status.Permanent = TAMPER_CLOSED
+		}
+		if status.Current == 0 {
+			status.Current = TAMPER_CLOSED
+		}
+		if layout.TTStatusOffset < 0 || layout.TTStatusOffset > len(file)-2 {
+			return nil, fmt.Errorf("%w: TTStatus at offset %d does not fit in %d bytes", ErrWrongLength, layout.TTStatusOffset, len(file))
+		}
+		copy(file[layout.TTStatusOffset:], status.String())
+	}
+
+	if layout.SDMMACOffset != OFFSET_NONE {
+		if layout.SDMMACInputOffset < 0 || layout.SDMMACInputOffset > layout.SDMMACOffset || layout.SDMMACOffset > len(file) {
+			return nil, fmt.Errorf("%w: SDMMACInputOffset %d is not before SDMMACOffset %d", ErrWrongLength, layout.SDMMACInputOffset, layout.SDMMACOffset)
+		}
+		code, err := meta.validationCode(file[layout.SDMMACInputOffset:layout.SDMMACOffset])
+		if err != nil {
+			return nil, err
+		}
+		if err := mirrorField(file, "SDMMAC", layout.SDMMACOffset, code); err != nil {
+			return nil, err
+		}
+	}
+
+	tag.ReadCounter = readCounter
+	return file, nil
+}
+
+// mirrorField writes the value into the file as uppercase hex, as the chip does.
+func mirrorField(file []byte, name string, offset int, value []byte) error {
+	ascii := strings.ToUpper(hex.EncodeToString(value))
+	if offset < 0 || offset > len(file)-len(ascii) {
+		return fmt.Errorf("%w: %s at offset %d does not fit in %d bytes", ErrWrongLength, name, offset, len(file))
+	}
+	copy(file[offset:], ascii)
+	return nil
+}
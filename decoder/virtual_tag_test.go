@@ -0,0 +1,135 @@
+package decoder
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testVirtualTagFile builds an NDEF file for the URL, and sets the offsets to
+// just after the given markers (which are followed by placeholder zeros).
+func testVirtualTagFile(url string, markers map[string]*int) []byte {
+	file := NDEFFileFromURL(url)
+	for marker, offset := range markers {
+		*offset = strings.Index(string(file), marker) + len(marker)
+	}
+	return file
+}
+
+func TestVirtualTag(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, mode := range []EncryptionMode{AES, LRP} {
+		keyset := testVerifyKeyset(mode)
+		keyset.FileReadKey = 1
+		url := "https://sdm.example.com/t?p=" + strings.Repeat("0", keyset.PICCDataLength()) + "&e=" + strings.Repeat("0", 64) + "&m=" + strings.Repeat("0", 16)
+		layout := NewSDMLayout()
+		file := testVirtualTagFile(url, map[string]*int{
+			"p=": &layout.PICCDataOffset,
+			"e=": &layout.SDMENCOffset,
+			"m=": &layout.SDMMACOffset,
+		})
+		layout.SDMENCLength = 64
+		layout.SDMMACInputOffset = layout.PICCDataOffset
+
+		tag := VirtualTag{
+			Uid:         36136180498510340,
+			ReadCounter: 5,
+			Keyset:      keyset,
+			Layout:      layout,
+			File:        file,
+			FileData:    []byte("serial number 0000000000000001!!"),
+			Rand:        random,
+		}
+		for i := int32(6); i < 10; i++ {
+			tapUrl, err := tag.Tap()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(tapUrl, "https://sdm.example.com/t?p=") || len(tapUrl) != len(url) {
+				t.Errorf("Bad URL: %s", tapUrl)
+			}
+
+			result, err := keyset.VerifyLayoutURL(layout, tapUrl)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !result.Validated {
+				t.Errorf("Not validated, but should have been")
+			}
+			if result.Meta.Uid != tag.Uid || result.Meta.ReadCounter != i {
+				t.Errorf("Wrong meta: %d / %d", result.Meta.Uid, result.Meta.ReadCounter)
+			}
+			if string(result.FileData) != string(tag.FileData) {
+				t.Errorf("Bad file data: %s", result.FileData)
+			}
+		}
+	}
+}
+
+func TestVirtualTagPlainMirror(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	keyset.MetaReadKey = KEY_NONE
+	layout := NewSDMLayout()
+	file := testVirtualTagFile("https://x.example/t?u=00000000000000x000000&m=0000000000000000", map[string]*int{
+		"u=": &layout.UIDOffset,
+		"0x": &layout.SDMReadCtrOffset,
+		"m=": &layout.SDMMACOffset,
+	})
+	layout.SDMMACInputOffset = layout.SDMMACOffset
+	tag := VirtualTag{
+		Uid:         36137992980951300,
+		ReadCounter: 2,
+		Keyset:      keyset,
+		Layout:      layout,
+		File:        file,
+	}
+	tapUrl, err := tag.Tap()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Same as the example in TestKeyset
+	if tapUrl != "https://x.example/t?u=0471862A506380x000003&m=637618472FE7D110" {
+		t.Errorf("Bad URL: %s", tapUrl)
+	}
+}
+
+func TestVirtualTagReadCounter(t *testing.T) {
+	keyset := testVerifyKeyset(AES)
+	keyset.MetaReadKey = KEY_NONE
+	layout := NewSDMLayout()
+	file := testVirtualTagFile("https://x.example/t?u=00000000000000x000000&m=0000000000000000", map[string]*int{
+		"u=": &layout.UIDOffset,
+		"0x": &layout.SDMReadCtrOffset,
+		"m=": &layout.SDMMACOffset,
+	})
+	layout.SDMMACInputOffset = layout.SDMMACOffset
+	tag := VirtualTag{
+		Uid:         36137992980951300,
+		ReadCounter: MAX_READ_COUNTER - 1,
+		Keyset:      keyset,
+		Layout:      layout,
+		File:        file,
+	}
+
+	// A failed tap doesn't use up a read counter
+	tag.Layout.SDMMACOffset = len(file)
+	if _, err := tag.Tap(); err == nil {
+		t.Errorf("Expected an error for a MAC outside the file")
+	}
+	if tag.ReadCounter != MAX_READ_COUNTER-1 {
+		t.Errorf("Failed tap changed the read counter to %d", tag.ReadCounter)
+	}
+
+	tag.Layout = layout
+	tapUrl, err := tag.Tap()
+	if err != nil || !strings.Contains(tapUrl, "xFFFFFF") {
+		t.Errorf("Expected a tap with the last read counter, received %s (%v)", tapUrl, err)
+	}
+	if _, err := tag.Tap(); !errors.Is(err, ErrReadCounterExhausted) {
+		t.Errorf("Expected exhausted error, received %v", err)
+	}
+	if tag.ReadCounter != MAX_READ_COUNTER {
+		t.Errorf("Expected %d, received %d", MAX_READ_COUNTER, tag.ReadCounter)
+	}
+}
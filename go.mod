@@ -1,8 +1,12 @@
 module github.com/johnnyb/nfc-sun-decoder
 
-go 1.16
+go 1.18
 
 require (
-	github.com/aead/cmac v0.0.0-20160719120800-7af84192f0b1 // indirect
-	github.com/johnnyb/gocrypto v0.1.4 // indirect
+	github.com/aead/cmac v0.0.0-20160719120800-7af84192f0b1
+	github.com/johnnyb/gocrypto v0.1.4
 )
+
+require github.com/mattn/go-sqlite3 v1.14.16
+
+require gopkg.in/yaml.v3 v3.0.1
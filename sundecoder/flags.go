@@ -8,3 +8,8 @@ var fileKeyData = flag.String("file-read-key", "", "The key used for reading fil
 var macKeyData = flag.String("mac-key", "", "The key used for authenticating messages")
 var macKeyApplicationData = flag.String("mac-key-application", "", "If set, this makes the MAC key a diversified key.  This is used as the application data for diversification.")
 var usesLrpData = flag.Bool("use-lrp", false, "Set this flag to use LRP encryption")
+var tapUrl = flag.String("url", "", "The full URL the phone opened (requires -url-template, or a -config keyset with a template or layout)")
+var ndefData = flag.String("ndef", "", "The raw NDEF message the phone read, in hex (requires -url-template, or a -config keyset with a template or layout)")
+var urlTemplateData = flag.String("url-template", "", "The URL template, such as https://x.example/t?p={picc}&m={cmac}")
+var configFile = flag.String("config", "", "A keyset configuration file to read the keyset from (instead of the key flags)")
+var keysetName = flag.String("keyset", "", "The name of the keyset to use from -config (defaults to the first one)")
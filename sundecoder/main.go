@@ -1,13 +1,22 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
+
+	"github.com/johnnyb/nfc-sun-decoder/decoder"
 )
 
 func main() {
 	flag.Parse()
-	keyset := readKeyset()
+	config := readKeysetConfig()
+	keyset := &config.Keyset
+
+	if *tapUrl != "" || *ndefData != "" {
+		decodeTemplated(config)
+		return
+	}
 
 	if *piccData == "" {
 		panic("No data specified to decode!")
@@ -16,3 +25,38 @@ func main() {
 	meta, validated := keyset.DecodeEncryptedMetaStringWithAuthenticator(*piccData, *macCode)
 	fmt.Printf("ChipUID: %s\nReadCounter: %d\nValidated: %t\n", meta.UidHex(), meta.ReadCounter, validated)	
 }
+
+func decodeTemplated(config decoder.KeysetConfig) {
+	keyset := &config.Keyset
+	template := config.Template
+	if *urlTemplateData != "" {
+		var err error
+		template, err = decoder.ParseURLTemplate(*urlTemplateData)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var result decoder.Result
+	var err error
+	switch {
+	case template != nil && *ndefData != "":
+		result, err = keyset.VerifyNDEF(template, mustDecodePtr(ndefData))
+	case template != nil:
+		result, err = keyset.VerifyURL(template, *tapUrl)
+	case config.Layout != nil && *ndefData != "":
+		result, err = keyset.VerifyNDEFLayout(*config.Layout, mustDecodePtr(ndefData))
+	case config.Layout != nil:
+		result, err = keyset.VerifyLayoutURL(*config.Layout, *tapUrl)
+	default:
+		panic("No URL template or layout specified!")
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("ChipUID: %s\nReadCounter: %d\nValidated: %t\n", result.Meta.UidHex(), result.Meta.ReadCounter, result.Validated)
+	if result.FileData != nil {
+		fmt.Printf("FileData: %s\n", hex.EncodeToString(result.FileData))
+	}
+}
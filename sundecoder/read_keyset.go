@@ -43,3 +43,22 @@ func readKeyset() *decoder.Keyset {
 	return keyset
 }
 
+
+func readKeysetConfig() decoder.KeysetConfig {
+	if *configFile == "" {
+		return decoder.KeysetConfig{
+			Keyset: *readKeyset(),
+		}
+	}
+
+	configs, err := decoder.LoadKeysetConfig(*configFile)
+	if err != nil {
+		panic(err)
+	}
+	for _, config := range configs {
+		if *keysetName == "" || config.Name == *keysetName {
+			return config
+		}
+	}
+	panic("Keyset not found in configuration: " + *keysetName)
+}